@@ -0,0 +1,57 @@
+package vermouth
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HttpContext 屏蔽不同路由框架之间的差异，每个请求对应一个
+type HttpContext interface {
+	Request() *http.Request
+	Writer() http.ResponseWriter
+	// 路径参数，例如 /users/:id 中的id
+	Param(name string) string
+	// 请求范围内的变量
+	Get(key string) (interface{}, bool)
+	Set(key string, value interface{})
+	JSON(code int, obj interface{})
+	// 是否已经输出了响应
+	Written() bool
+}
+
+// RouterAdapter 将控制器的接口挂载到具体的路由上
+// 路径使用gin的写法，例如 /users/:id
+type RouterAdapter interface {
+	Handle(method, path string, handler func(HttpContext))
+}
+
+var (
+	adapterMu sync.Mutex
+	// 同一个ServeMux共用一个适配器，否则同一路径会被重复注册
+	serveMuxAdapters = map[*http.ServeMux]*serveMuxAdapter{}
+)
+
+// 将RegisterControllers支持的router转换为适配器
+func toRouterAdapter(r interface{}) (RouterAdapter, bool) {
+	switch v := r.(type) {
+	case RouterAdapter:
+		return v, true
+	case *gin.Engine:
+		return NewGinAdapter(v), true
+	case *gin.RouterGroup:
+		return NewGinAdapter(v), true
+	case *http.ServeMux:
+		adapterMu.Lock()
+		defer adapterMu.Unlock()
+		adapter, ok := serveMuxAdapters[v]
+		if !ok {
+			adapter = NewServeMuxAdapter(v).(*serveMuxAdapter)
+			serveMuxAdapters[v] = adapter
+		}
+		return adapter, true
+	default:
+		return nil, false
+	}
+}
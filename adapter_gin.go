@@ -0,0 +1,54 @@
+package vermouth
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ginAdapter struct {
+	router gin.IRoutes
+}
+
+// NewGinAdapter 挂载到*gin.Engine或*gin.RouterGroup上
+func NewGinAdapter(router gin.IRoutes) RouterAdapter {
+	return &ginAdapter{router: router}
+}
+
+func (a *ginAdapter) Handle(method, path string, handler func(HttpContext)) {
+	a.router.Handle(method, path, func(c *gin.Context) {
+		handler(&ginContext{c: c})
+	})
+}
+
+type ginContext struct {
+	c *gin.Context
+}
+
+func (g *ginContext) Request() *http.Request {
+	return g.c.Request
+}
+
+func (g *ginContext) Writer() http.ResponseWriter {
+	return g.c.Writer
+}
+
+func (g *ginContext) Param(name string) string {
+	return g.c.Param(name)
+}
+
+func (g *ginContext) Get(key string) (interface{}, bool) {
+	return g.c.Get(key)
+}
+
+func (g *ginContext) Set(key string, value interface{}) {
+	g.c.Set(key, value)
+}
+
+func (g *ginContext) JSON(code int, obj interface{}) {
+	g.c.JSON(code, obj)
+}
+
+func (g *ginContext) Written() bool {
+	return g.c.Writer.Written()
+}
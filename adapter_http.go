@@ -0,0 +1,173 @@
+package vermouth
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type serveMuxAdapter struct {
+	mu  sync.RWMutex
+	mux *http.ServeMux
+	// 在ServeMux上注册的pattern，以及该pattern下的所有接口
+	patterns map[string][]*muxRoute
+}
+
+type muxRoute struct {
+	method   string
+	segments []string
+	handler  func(HttpContext)
+}
+
+// NewServeMuxAdapter 挂载到标准库的*http.ServeMux上
+// 支持gin风格的路径参数，:name匹配一段，*name匹配剩余的所有路径
+func NewServeMuxAdapter(mux *http.ServeMux) RouterAdapter {
+	return &serveMuxAdapter{
+		mux:      mux,
+		patterns: make(map[string][]*muxRoute),
+	}
+}
+
+func (a *serveMuxAdapter) Handle(method, path string, handler func(HttpContext)) {
+	segments := splitPath(path)
+	// 带参数的路径，注册参数之前的部分，由dispatch进行匹配
+	pattern := path
+	for i, segment := range segments {
+		if segment[0] == ':' || segment[0] == '*' {
+			pattern = "/" + strings.Join(segments[:i], "/")
+			if i > 0 {
+				pattern += "/"
+			}
+			break
+		}
+	}
+	a.mu.Lock()
+	_, exists := a.patterns[pattern]
+	a.patterns[pattern] = append(a.patterns[pattern], &muxRoute{method: method, segments: segments, handler: handler})
+	a.mu.Unlock()
+	if !exists {
+		a.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			a.dispatch(pattern, w, r)
+		})
+	}
+}
+
+func (a *serveMuxAdapter) dispatch(pattern string, w http.ResponseWriter, r *http.Request) {
+	a.mu.RLock()
+	routes := a.patterns[pattern]
+	a.mu.RUnlock()
+	segments := splitPath(r.URL.Path)
+	methodNotAllowed := false
+	for _, route := range routes {
+		params, ok := route.match(segments)
+		if !ok {
+			continue
+		}
+		if route.method != r.Method {
+			methodNotAllowed = true
+			continue
+		}
+		route.handler(&httpContext{
+			request: r,
+			writer:  &trackingWriter{ResponseWriter: w},
+			params:  params,
+		})
+		return
+	}
+	if methodNotAllowed {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func (route *muxRoute) match(segments []string) (map[string]string, bool) {
+	params := make(map[string]string)
+	for i, segment := range route.segments {
+		if segment[0] == '*' {
+			params[segment[1:]] = "/" + strings.Join(segments[i:], "/")
+			return params, true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		if segment[0] == ':' {
+			params[segment[1:]] = segments[i]
+		} else if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, len(segments) == len(route.segments)
+}
+
+func splitPath(path string) []string {
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// 记录是否已经输出了响应
+type trackingWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *trackingWriter) WriteHeader(code int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *trackingWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+type httpContext struct {
+	request *http.Request
+	writer  *trackingWriter
+	params  map[string]string
+	values  map[string]interface{}
+}
+
+func (h *httpContext) Request() *http.Request {
+	return h.request
+}
+
+func (h *httpContext) Writer() http.ResponseWriter {
+	return h.writer
+}
+
+func (h *httpContext) Param(name string) string {
+	return h.params[name]
+}
+
+func (h *httpContext) Get(key string) (interface{}, bool) {
+	value, ok := h.values[key]
+	return value, ok
+}
+
+func (h *httpContext) Set(key string, value interface{}) {
+	if h.values == nil {
+		h.values = make(map[string]interface{})
+	}
+	h.values[key] = value
+}
+
+func (h *httpContext) JSON(code int, obj interface{}) {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		panic(err)
+	}
+	h.writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	h.writer.WriteHeader(code)
+	h.writer.Write(body)
+}
+
+func (h *httpContext) Written() bool {
+	return h.writer.written
+}
@@ -23,6 +23,8 @@ type Context struct {
 	// 返回值
 	Result []interface{}
 	// 上下文环境
+	HttpContext HttpContext
+	// 使用gin时才有值
 	GinContext *gin.Context
 
 	// // 控制器信息
@@ -34,11 +36,19 @@ type Context struct {
 
 	// 是否自动返回前端
 	AutoReturn bool
+
+	traceId string
 }
 
 type ControllerInformation struct {
 	Path        string
 	Transaction bool
+	// 事务使用的数据源和选项
+	TxOptions TxOptions
+	// 按返回值判断是否回滚的规则名
+	RollbackWhen []string
+	// 不使用统一返回结构
+	Raw bool
 
 	Attributes map[string]string
 }
@@ -53,6 +63,11 @@ func (aopContext *Context) Call() {
 	aopContext.Fn()
 }
 
+// JSON 向前端输出json
+func (aopContext *Context) JSON(code int, obj interface{}) {
+	aopContext.HttpContext.JSON(code, obj)
+}
+
 func newAopContext(argumentsLength int) *Context {
 	return &Context{
 		Arguments:     make([]interface{}, argumentsLength),
@@ -61,6 +76,16 @@ func newAopContext(argumentsLength int) *Context {
 	}
 }
 
+// 当前请求的上下文，由generateApi设置
+var currentContext = NewThreadLocal[*Context]()
+
+// CurrentContext 获取当前请求的上下文，不在请求中时返回nil
+// 在控制器调用的service中可以直接使用，使用Group启动的子协程中同样可以获取
+func CurrentContext() *Context {
+	ctx, _ := currentContext.Get()
+	return ctx
+}
+
 var aopItems []*aopItem = make([]*aopItem, 0)
 
 func RegisterAop(exp string, order int, fn func(*Context)) {
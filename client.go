@@ -0,0 +1,494 @@
+package vermouth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ClientInterceptor 客户端拦截器，可以用于鉴权、重试、链路追踪等
+// 调用next继续发送请求，不调用则直接使用返回的结果
+type ClientInterceptor func(req *http.Request, next func(*http.Request) (*http.Response, error)) (*http.Response, error)
+
+// Client 声明式的http客户端，写法与控制器相同
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// 服务端使用了统一返回时，设置后会自动解包data，code不是成功时返回 *RuntimeError
+	Envelope *ResponseEnvelope
+
+	interceptors []ClientInterceptor
+}
+
+type clientMethod struct {
+	client    *Client
+	index     int
+	method    string
+	path      string
+	params    []*paramItem
+	fnType    reflect.Type
+	ctxIndex  int
+	bodyIndex int
+}
+
+var goContextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// NewClient 为svc中所有带有method和path的方法字段生成实现
+// svc必须是结构体指针，定义有问题时返回 *DefinitionError
+//
+//	type UserService struct {
+//		GetUser func(id int) (*User, error) `method:"GET" path:"/users/:id" params:"id=path"`
+//	}
+func NewClient(svc interface{}, baseURL string, interceptors ...ClientInterceptor) (*Client, error) {
+	client := &Client{
+		BaseURL:      strings.TrimRight(baseURL, "/"),
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second},
+		interceptors: interceptors,
+	}
+	v := reflect.ValueOf(svc)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil, &DefinitionError{Messages: []string{fmt.Sprintf("client %T must be a pointer to struct", svc)}}
+	}
+	v = v.Elem()
+	t := v.Type()
+	de := &DefinitionError{}
+	var methods []*clientMethod
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Type.Kind() != reflect.Func || (field.Tag.Get("method") == "" && field.Tag.Get("path") == "") {
+			continue
+		}
+		m, problems := parseClientMethod(client, t.Name()+"."+field.Name, field)
+		m.index = i
+		methods = append(methods, m)
+		de.Messages = append(de.Messages, problems...)
+	}
+	// 有任何问题都不生成实现
+	if len(de.Messages) > 0 {
+		return nil, de
+	}
+	for _, m := range methods {
+		v.Field(m.index).Set(reflect.MakeFunc(m.fnType, m.call))
+	}
+	return client, nil
+}
+
+// Use 追加拦截器，先添加的先执行
+func (c *Client) Use(interceptors ...ClientInterceptor) {
+	c.interceptors = append(c.interceptors, interceptors...)
+}
+
+func parseClientMethod(client *Client, name string, field reflect.StructField) (*clientMethod, []string) {
+	m := &clientMethod{
+		client:    client,
+		method:    field.Tag.Get("method"),
+		path:      field.Tag.Get("path"),
+		fnType:    field.Type,
+		ctxIndex:  -1,
+		bodyIndex: -1,
+	}
+	var problems []string
+	if m.method == "" {
+		problems = append(problems, fmt.Sprintf("%s: missing method tag", name))
+	}
+	if m.path == "" {
+		problems = append(problems, fmt.Sprintf("%s: missing path tag", name))
+	}
+	if params := field.Tag.Get("params"); params != "" {
+		for _, param := range strings.Split(params, ",") {
+			param = strings.TrimSpace(param)
+			parts := strings.SplitN(param, "=", 2)
+			if len(parts) == 2 {
+				m.params = append(m.params, &paramItem{ParamName: parts[0], From: parts[1]})
+			} else {
+				m.params = append(m.params, &paramItem{ParamName: param, From: defaultParamFrom(m.method)})
+			}
+		}
+	}
+	// 参数和params一一对应，context.Context不需要声明
+	named := 0
+	for i := 0; i < m.fnType.NumIn(); i++ {
+		in := m.fnType.In(i)
+		if in == goContextType {
+			m.ctxIndex = i
+			continue
+		}
+		if named >= len(m.params) {
+			problems = append(problems, fmt.Sprintf("%s: argument #%d (%v) has no name in params tag", name, i, in))
+			continue
+		}
+		pi := m.params[named]
+		named++
+		switch pi.From {
+		case "path":
+			if findPathParam(strings.Split(m.path, "/"), pi.ParamName) < 0 {
+				problems = append(problems, fmt.Sprintf("%s: path param %s not found in %s", name, pi.ParamName, m.path))
+			}
+		case "json":
+			if isBodyType(in) {
+				if m.bodyIndex >= 0 {
+					problems = append(problems, fmt.Sprintf("%s: only one json body is allowed", name))
+				}
+				m.bodyIndex = i
+			}
+		case "query", "header", "form":
+		default:
+			problems = append(problems, fmt.Sprintf("%s: param %s has unsupported source %q", name, pi.ParamName, pi.From))
+		}
+	}
+	if named < len(m.params) {
+		problems = append(problems, fmt.Sprintf("%s: params declares %d names but func takes %d arguments", name, len(m.params), named))
+	}
+	if m.bodyIndex >= 0 {
+		for _, pi := range m.params {
+			if pi.From == "json" && pi != m.paramOf(m.bodyIndex) {
+				problems = append(problems, fmt.Sprintf("%s: json param %s can not be mixed with a json body", name, pi.ParamName))
+			}
+		}
+	}
+	for i := 0; i < m.fnType.NumOut(); i++ {
+		if m.fnType.Out(i) == errorType && i != m.fnType.NumOut()-1 {
+			problems = append(problems, fmt.Sprintf("%s: error must be the last return value", name))
+		}
+	}
+	if m.fnType.NumOut() > 2 {
+		problems = append(problems, fmt.Sprintf("%s: at most 2 return values are supported", name))
+	}
+	return m, problems
+}
+
+// 路径中与参数同名的段的下标，:name或*name需要是完整的一段
+func findPathParam(segments []string, name string) int {
+	for i, segment := range segments {
+		if segment == ":"+name || segment == "*"+name {
+			return i
+		}
+	}
+	return -1
+}
+
+// 作为整个请求体发送的参数类型
+func isBodyType(t reflect.Type) bool {
+	t = getReadType(t)
+	return isStructOrMap(t) || t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8
+}
+
+func isStructOrMap(t reflect.Type) bool {
+	t = getReadType(t)
+	return t.Kind() == reflect.Struct || t.Kind() == reflect.Map
+}
+
+// 第i个参数对应的paramItem
+func (m *clientMethod) paramOf(index int) *paramItem {
+	named := 0
+	for i := 0; i < index; i++ {
+		if i != m.ctxIndex {
+			named++
+		}
+	}
+	if named < len(m.params) {
+		return m.params[named]
+	}
+	return nil
+}
+
+func (m *clientMethod) call(args []reflect.Value) []reflect.Value {
+	res, err := m.do(args)
+	return m.returns(res, err)
+}
+
+func (m *clientMethod) do(args []reflect.Value) (reflect.Value, error) {
+	ctx := context.Background()
+	segments := strings.Split(m.path, "/")
+	query := url.Values{}
+	form := url.Values{}
+	header := http.Header{}
+	jsonFields := map[string]interface{}{}
+	var body interface{}
+	for i, arg := range args {
+		if i == m.ctxIndex {
+			if !arg.IsNil() {
+				ctx = arg.Interface().(context.Context)
+			}
+			continue
+		}
+		pi := m.paramOf(i)
+		if i == m.bodyIndex {
+			body = arg.Interface()
+			continue
+		}
+		switch pi.From {
+		case "path":
+			index := findPathParam(segments, pi.ParamName)
+			if strings.HasPrefix(segments[index], "*") {
+				segments[index] = strings.TrimLeft(formatParam(arg), "/")
+			} else {
+				segments[index] = url.PathEscape(formatParam(arg))
+			}
+		case "query":
+			if isStructOrMap(arg.Type()) {
+				encodeStruct(query, arg)
+			} else if value, ok := paramValue(arg); ok {
+				query.Set(pi.ParamName, value)
+			}
+		case "form":
+			if isStructOrMap(arg.Type()) {
+				encodeStruct(form, arg)
+			} else if value, ok := paramValue(arg); ok {
+				form.Set(pi.ParamName, value)
+			}
+		case "header":
+			if value, ok := paramValue(arg); ok {
+				header.Set(pi.ParamName, value)
+			}
+		case "json":
+			jsonFields[pi.ParamName] = arg.Interface()
+		}
+	}
+	if body == nil && len(jsonFields) > 0 {
+		body = jsonFields
+	}
+
+	fullURL := m.client.BaseURL + strings.Join(segments, "/")
+	if len(query) > 0 {
+		fullURL += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		reader = bytes.NewReader(data)
+		header.Set("Content-Type", "application/json")
+	} else if len(form) > 0 {
+		reader = strings.NewReader(form.Encode())
+		header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req, err := http.NewRequestWithContext(ctx, m.method, fullURL, reader)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := m.client.send(req)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return reflect.Value{}, m.client.statusError(resp.StatusCode, data)
+	}
+	return m.client.decode(m.resultType(), data)
+}
+
+// 依次执行拦截器，最后发送请求
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	next := c.HTTPClient.Do
+	for i := len(c.interceptors) - 1; i >= 0; i-- {
+		interceptor, inner := c.interceptors[i], next
+		next = func(req *http.Request) (*http.Response, error) {
+			return interceptor(req, inner)
+		}
+	}
+	return next(req)
+}
+
+func (c *Client) statusError(status int, data []byte) error {
+	envelope := c.Envelope
+	if envelope == nil {
+		envelope = defaultEnvelope
+	}
+	body := map[string]interface{}{}
+	if json.Unmarshal(data, &body) == nil {
+		if message, ok := body[envelope.MessageField].(string); ok {
+			return NewRuntimeError(status, message)
+		}
+	}
+	message := strings.TrimSpace(string(data))
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	return NewRuntimeError(status, message)
+}
+
+func (c *Client) decode(t reflect.Type, data []byte) (reflect.Value, error) {
+	if t == nil {
+		return reflect.Value{}, nil
+	}
+	if c.Envelope != nil {
+		body := map[string]json.RawMessage{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			return reflect.Value{}, err
+		}
+		code := 0
+		json.Unmarshal(body[c.Envelope.CodeField], &code)
+		if code != c.Envelope.SuccessCode {
+			message := ""
+			json.Unmarshal(body[c.Envelope.MessageField], &message)
+			return reflect.Value{}, NewRuntimeError(code, message)
+		}
+		data = body[c.Envelope.DataField]
+	}
+	result := reflect.New(t)
+	switch {
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		result.Elem().SetBytes(data)
+	case t.Kind() == reflect.String && !json.Valid(data):
+		result.Elem().SetString(string(data))
+	case len(data) > 0:
+		if err := json.Unmarshal(data, result.Interface()); err != nil {
+			return reflect.Value{}, err
+		}
+	}
+	return result.Elem(), nil
+}
+
+// 需要解码的返回值类型，没有则返回nil
+func (m *clientMethod) resultType() reflect.Type {
+	if m.fnType.NumOut() > 0 && m.fnType.Out(0) != errorType {
+		return m.fnType.Out(0)
+	}
+	return nil
+}
+
+func (m *clientMethod) returns(res reflect.Value, err error) []reflect.Value {
+	outs := make([]reflect.Value, m.fnType.NumOut())
+	for i := range outs {
+		out := m.fnType.Out(i)
+		switch {
+		case out == errorType:
+			if err != nil {
+				outs[i] = reflect.ValueOf(&err).Elem()
+			} else {
+				outs[i] = reflect.Zero(out)
+			}
+		case res.IsValid():
+			outs[i] = res
+		default:
+			outs[i] = reflect.Zero(out)
+		}
+	}
+	// 没有error返回值时，与控制器一样抛出异常
+	if err != nil && (len(outs) == 0 || m.fnType.Out(len(outs)-1) != errorType) {
+		panic(err)
+	}
+	return outs
+}
+
+// 参数转换为字符串，空指针不发送
+func paramValue(v reflect.Value) (string, bool) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", false
+		}
+		v = v.Elem()
+	}
+	return formatParam(v), true
+}
+
+func formatParam(v reflect.Value) string {
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	// 切片使用逗号拼接，与服务端的解析方式保持一致
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+		parts := make([]string, v.Len())
+		for i := range parts {
+			parts[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return strings.Join(parts, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// 将结构体或map编码到query、form中，字段名优先使用form tag，其次json tag
+func encodeStruct(values url.Values, v reflect.Value) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			values.Set(fmt.Sprint(key.Interface()), formatParam(v.MapIndex(key)))
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !isExported(field.Name) {
+				continue
+			}
+			name := field.Name
+			for _, tagName := range []string{"form", "json"} {
+				if tag := strings.Split(field.Tag.Get(tagName), ",")[0]; tag != "" {
+					name = tag
+					break
+				}
+			}
+			if name == "-" {
+				continue
+			}
+			if value, ok := paramValue(v.Field(i)); ok {
+				values.Set(name, value)
+			}
+		}
+	}
+}
+
+// RetryInterceptor 请求失败或者服务端返回5xx时重试
+func RetryInterceptor(times int, wait time.Duration) ClientInterceptor {
+	return func(req *http.Request, next func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+		var resp *http.Response
+		var err error
+		for i := 0; i <= times; i++ {
+			if i > 0 {
+				// 重新设置请求体
+				if req.GetBody != nil {
+					if req.Body, err = req.GetBody(); err != nil {
+						return nil, err
+					}
+				}
+				select {
+				case <-req.Context().Done():
+					return nil, req.Context().Err()
+				case <-time.After(wait):
+				}
+			}
+			resp, err = next(req)
+			if err == nil && resp.StatusCode < 500 {
+				return resp, nil
+			}
+			if i < times && resp != nil {
+				resp.Body.Close()
+			}
+		}
+		return resp, err
+	}
+}
+
+// HeaderInterceptor 为每个请求设置请求头，例如鉴权的token、链路追踪的traceId
+func HeaderInterceptor(name string, value func(req *http.Request) string) ClientInterceptor {
+	return func(req *http.Request, next func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+		if v := value(req); v != "" {
+			req.Header.Set(name, v)
+		}
+		return next(req)
+	}
+}
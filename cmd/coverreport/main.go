@@ -0,0 +1,74 @@
+// coverreport 汇总cover_url的不一致日志，生成报告，或者重放日志中的请求
+//
+//	go run github.com/llyb120/vermouth/cmd/coverreport -dir ./logs -format html -out report.html
+//	go run github.com/llyb120/vermouth/cmd/coverreport -dir ./logs -replay http://localhost:8080
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/llyb120/vermouth"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "cover_url的日志目录")
+	format := flag.String("format", "markdown", "报告格式，markdown或html")
+	out := flag.String("out", "", "报告的输出文件，默认输出到标准输出")
+	replay := flag.String("replay", "", "重放日志中的请求到该地址，例如 http://localhost:8080")
+	timeout := flag.Duration("timeout", 10*time.Second, "重放请求的超时时间")
+	flag.Parse()
+
+	if *replay != "" {
+		os.Exit(runReplay(*dir, *replay, *timeout))
+	}
+	report, err := vermouth.BuildCoverReport(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	content := report.Markdown()
+	if *format == "html" {
+		content = report.HTML()
+	}
+	if *out == "" {
+		fmt.Print(content)
+		return
+	}
+	if err = os.WriteFile(*out, []byte(content), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// 有未修复的请求时返回1
+func runReplay(dir, baseURL string, timeout time.Duration) int {
+	logs, err := vermouth.LoadCoverLogs(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fixed := 0
+	for _, result := range vermouth.ReplayCoverLogs(logs, baseURL, &http.Client{Timeout: timeout}) {
+		switch {
+		case result.Err != nil:
+			fmt.Printf("ERROR %s: %v\n", result.Log.File, result.Err)
+		case result.Fixed():
+			fixed++
+			fmt.Printf("FIXED %s\n", result.Log.File)
+		default:
+			fmt.Printf("DIFF  %s: %d differences\n", result.Log.File, len(result.Diff))
+			for _, diff := range result.Diff {
+				fmt.Printf("      %s %s: %v -> %v\n", diff.Kind, diff.Path, diff.Original, diff.Duplicate)
+			}
+		}
+	}
+	fmt.Printf("%d/%d fixed\n", fixed, len(logs))
+	if fixed < len(logs) {
+		return 1
+	}
+	return 0
+}
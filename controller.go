@@ -2,9 +2,14 @@ package vermouth
 
 import (
 	"database/sql"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
+	"net/http"
+	"path"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -25,18 +30,111 @@ type controllerDefinition struct {
 	Transaction bool
 }
 
+// DefinitionError 控制器定义错误，包含检查出的所有问题
+type DefinitionError struct {
+	Messages []string
+}
+
+func (e *DefinitionError) Error() string {
+	return "invalid controller definition:\n\t" + strings.Join(e.Messages, "\n\t")
+}
+
+// 解析后的单个接口
+type routeDefinition struct {
+	controller  *controllerDefinition
+	structName  string
+	fieldName   string
+	api         *requestMapping
+	method      reflect.Value
+	information *ControllerInformation
+	// 设置了cover_url时才有值
+	cover *coverRoute
+}
+
+func (route *routeDefinition) String() string {
+	return route.structName + "." + route.fieldName
+}
+
+var (
+	// 控制器上允许出现的tag，其余的tag在严格模式下会报错
+	controllerTagKeys = map[string]struct{}{
+		"path": {}, "name": {}, "method": {}, "params": {}, "transaction": {}, "cover_url": {}, "raw": {},
+		"rollback_for": {}, "no_rollback_for": {}, "rollback_when": {},
+		"cover_ignore": {}, "cover_tolerance": {}, "cover_unordered": {}, "cover_sample": {}, "cover_methods": {}, "cover_rollback": {}, "cover_target": {},
+		"cover_mode": {}, "cover_percent": {},
+	}
+	httpMethods = map[string]struct{}{
+		"GET": {}, "POST": {}, "PUT": {}, "DELETE": {}, "PATCH": {}, "HEAD": {}, "OPTIONS": {},
+	}
+	paramSources = map[string]struct{}{
+		"query": {}, "json": {}, "form": {}, "path": {}, "header": {},
+	}
+	// 已经注册过的路由，按router区分，同一个gin.Engine的不同分组共用一张表，用于检查重复的接口
+	registeredRoutes = map[interface{}]map[string]string{}
+)
+
+// RegisterTagKeys 声明自定义的tag，例如用于切面的 log:"true"，避免严格模式下报错
+func RegisterTagKeys(keys ...string) {
+	for _, key := range keys {
+		controllerTagKeys[key] = struct{}{}
+	}
+}
+
 func RegisterControllers(r interface{}, controller ...interface{}) {
+	if err := registerControllers(r, false, controller); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterControllersE 严格模式注册控制器
+// 注册前会检查所有控制器的定义，有任何问题都不会注册，并返回包含所有问题的 *DefinitionError
+func RegisterControllersE(r interface{}, controller ...interface{}) error {
+	return registerControllers(r, true, controller)
+}
+
+func registerControllers(r interface{}, strict bool, controllers []interface{}) error {
 	// 初始化事务管理器
 	// 只执行一次
 	initTransactionManager()
 	initValidator()
+
+	de := &DefinitionError{}
+	//r可能为*gin.Engine、*gin.RouterGroup、*http.ServeMux或者自定义的RouterAdapter
+	adapter, ok := toRouterAdapter(r)
+	if !ok {
+		de.Messages = append(de.Messages, fmt.Sprintf("unsupported router type %T", r))
+	}
+	var routes []*routeDefinition
+	for _, controller := range controllers {
+		t := reflect.TypeOf(controller)
+		if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+			de.Messages = append(de.Messages, fmt.Sprintf("controller %T must be a pointer to struct", controller))
+			continue
+		}
+		controllerRoutes, problems, invalid := parseController(controller)
+		routes = append(routes, controllerRoutes...)
+		if strict {
+			de.Messages = append(de.Messages, problems...)
+		} else {
+			// 非严格模式下同样不能忽略的问题，例如写错的事务隔离级别
+			de.Messages = append(de.Messages, invalid...)
+		}
+	}
+	if strict {
+		de.Messages = append(de.Messages, checkRoutes(r, routes)...)
+	}
+	if len(de.Messages) > 0 {
+		return de
+	}
 	// 注册控制器
-	for _, controller := range controller {
-		registerController(r, controller)
+	for _, route := range routes {
+		mountRoute(r, adapter, route)
 	}
+	return nil
 }
 
-func registerController(r interface{}, controller interface{}) {
+// 解析控制器，返回所有接口，在严格模式下需要报告的问题，以及任何模式下都需要报告的问题
+func parseController(controller interface{}) ([]*routeDefinition, []string, []string) {
 	controllerDefinition := &controllerDefinition{}
 	controllerType := reflect.TypeOf(controller)
 	controllerValue := reflect.ValueOf(controller)
@@ -44,7 +142,9 @@ func registerController(r interface{}, controller interface{}) {
 	// 解析控制器字段
 	controllerElemType := controllerType.Elem()
 	controllerFields := controllerElemType.NumField()
-	apiMap := make(map[string]*requestMapping)
+	structName := controllerElemType.Name()
+	var routes []*routeDefinition
+	var problems, invalid []string
 	globalField, ok := controllerElemType.FieldByName("_")
 	if ok {
 		globalFieldTag := globalField.Tag.Get("path")
@@ -55,6 +155,7 @@ func registerController(r interface{}, controller interface{}) {
 		if name != "" {
 			controllerDefinition.Name = name
 		}
+		problems = append(problems, checkTagKeys(structName+"._", globalField.Tag)...)
 	}
 	// 如果没有_，默认生成一个
 	// 如果没有起名，则默认用类名
@@ -66,19 +167,28 @@ func registerController(r interface{}, controller interface{}) {
 		if field.Name == "_" {
 			continue
 		}
+		// 获取字段上的标签
+		tag := field.Tag.Get("method")
+		path := field.Tag.Get("path")
 		// 该字段必须是一个方法
 		if field.Type.Kind() != reflect.Func {
+			if tag != "" || path != "" {
+				problems = append(problems, fmt.Sprintf("%s.%s: routed field must be a func, got %v", structName, field.Name, field.Type))
+			}
+			continue
+		}
+		if tag == "" && path == "" {
 			continue
 		}
-		// 获取字段上的标签
-		tag := field.Tag.Get("method")
 		if tag == "" {
+			problems = append(problems, fmt.Sprintf("%s.%s: missing method tag", structName, field.Name))
 			continue
 		}
-		path := field.Tag.Get("path")
 		if path == "" {
+			problems = append(problems, fmt.Sprintf("%s.%s: missing path tag", structName, field.Name))
 			continue
 		}
+		problems = append(problems, checkTagKeys(structName+"."+field.Name, field.Tag)...)
 		api := &requestMapping{Method: tag, Path: path}
 		params := field.Tag.Get("params")
 		if params != "" {
@@ -90,12 +200,7 @@ func registerController(r interface{}, controller interface{}) {
 				if len(paramParts) == 2 {
 					api.Params = append(api.Params, &paramItem{ParamName: paramParts[0], From: paramParts[1]})
 				} else {
-					// 默认GET请求从query中获取参数，POST请求从body中获取参数
-					if api.Method == "GET" {
-						api.Params = append(api.Params, &paramItem{ParamName: param, From: "query"})
-					} else {
-						api.Params = append(api.Params, &paramItem{ParamName: param, From: "json"})
-					}
+					api.Params = append(api.Params, &paramItem{ParamName: param, From: defaultParamFrom(api.Method)})
 				}
 			}
 		} else {
@@ -103,7 +208,6 @@ func registerController(r interface{}, controller interface{}) {
 		}
 		// 事务
 		transaction := field.Tag.Get("transaction")
-		apiMap[field.Name] = api
 
 		// 计算完整的路径
 		fullPath := controllerDefinition.Path
@@ -117,40 +221,236 @@ func registerController(r interface{}, controller interface{}) {
 
 		controllerInformation := NewControllerInformation()
 		controllerInformation.Path = fullPath
-		controllerInformation.Transaction = transaction == "true"
-		coverUrl := field.Tag.Get("cover_url")
-		if coverUrl != "" {
-			urlCoverCache.Store(coverUrl, fullPath)
+		transactionProblems := []string(nil)
+		controllerInformation.Transaction, controllerInformation.TxOptions, transactionProblems = parseTransactionTag(transaction)
+		// 事务选项错误时会使用默认的隔离级别，非严格模式下也不能忽略
+		for _, problem := range transactionProblems {
+			invalid = append(invalid, fmt.Sprintf("%s.%s: %s", structName, field.Name, problem))
 		}
-
+		controllerInformation.TxOptions.RollbackFor = splitTagList(field.Tag.Get("rollback_for"))
+		controllerInformation.TxOptions.NoRollbackFor = splitTagList(field.Tag.Get("no_rollback_for"))
+		controllerInformation.RollbackWhen = splitTagList(field.Tag.Get("rollback_when"))
+		for _, name := range controllerInformation.RollbackWhen {
+			if _, ok := rollbackRules[name]; !ok {
+				transactionProblems = append(transactionProblems, fmt.Sprintf("unknown rollback rule %q", name))
+			}
+		}
+		for _, problem := range transactionProblems {
+			problems = append(problems, fmt.Sprintf("%s.%s: %s", structName, field.Name, problem))
+		}
+		controllerInformation.Raw = field.Tag.Get("raw") == "true"
 		// tag整理成map
-		tagMap := make(map[string]string)
-		for _, tag := range strings.Split(string(field.Tag), " ") {
-			parts := strings.SplitN(tag, ":", 2)
-			if len(parts) == 2 {
-				key := strings.Trim(parts[0], `"`)
-				value := strings.Trim(parts[1], `"`)
-				tagMap[key] = value
+		controllerInformation.Attributes = parseTag(field.Tag)
+		// 渐进式覆盖
+		var cover *coverRoute
+		if controllerInformation.Attributes["cover_url"] != "" {
+			var coverProblems []string
+			cover, coverProblems = parseCoverRoute(fullPath, controllerInformation.Attributes)
+			for _, problem := range coverProblems {
+				problems = append(problems, fmt.Sprintf("%s.%s: %s", structName, field.Name, problem))
 			}
 		}
-		controllerInformation.Attributes = tagMap
-		//r可能为*gin.Engine或*gin.RouterGroup
-		switch v := r.(type) {
-		case *gin.Engine:
-			v.Handle(api.Method, fullPath, generateApi(controllerDefinition, field.Name, api, controllerValue.Elem().FieldByName(field.Name), controllerInformation))
-		case *gin.RouterGroup:
-			v.Handle(api.Method, fullPath, generateApi(controllerDefinition, field.Name, api, controllerValue.Elem().FieldByName(field.Name), controllerInformation))
-		default:
-			panic("unsupported router type")
+
+		routes = append(routes, &routeDefinition{
+			controller:  controllerDefinition,
+			structName:  structName,
+			fieldName:   field.Name,
+			api:         api,
+			method:      controllerValue.Elem().Field(i),
+			information: controllerInformation,
+			cover:       cover,
+		})
+	}
+	return routes, problems, invalid
+}
+
+// 检查接口的定义是否可以在运行时正确调用
+func checkRoutes(r interface{}, routes []*routeDefinition) []string {
+	var problems []string
+	scope, basePath := routeScope(r)
+	seen := map[string]string{}
+	for key, name := range registeredRoutes[scope] {
+		seen[key] = name
+	}
+	for _, route := range routes {
+		api := route.api
+		if _, ok := httpMethods[api.Method]; !ok {
+			problems = append(problems, fmt.Sprintf("%s: unsupported method %q", route, api.Method))
+		}
+		key := api.Method + " " + joinRoutePath(basePath, api.Path)
+		if name, ok := seen[key]; ok {
+			problems = append(problems, fmt.Sprintf("%s: duplicate route %s, already registered by %s", route, key, name))
+		} else {
+			seen[key] = route.String()
+		}
+		if route.method.IsNil() {
+			problems = append(problems, fmt.Sprintf("%s: func is nil", route))
+			continue
+		}
+		methodType := route.method.Type()
+		if len(api.Params) > methodType.NumIn() {
+			problems = append(problems, fmt.Sprintf("%s: params declares %d names but func takes %d arguments", route, len(api.Params), methodType.NumIn()))
+		}
+		for _, pi := range api.Params {
+			if _, ok := paramSources[pi.From]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: param %s has unsupported source %q", route, pi.ParamName, pi.From))
+			}
+		}
+		for i := 0; i < methodType.NumIn(); i++ {
+			in := methodType.In(i)
+			if isContextParam(in) {
+				continue
+			}
+			if i >= len(api.Params) {
+				problems = append(problems, fmt.Sprintf("%s: argument #%d (%v) has no name in params tag", route, i, in))
+			} else if !isSupportedParam(in) {
+				problems = append(problems, fmt.Sprintf("%s: argument #%d %s has unsupported type %v", route, i, api.Params[i].ParamName, in))
+			}
+		}
+	}
+	return problems
+}
+
+// 逗号分隔的tag值
+func splitTagList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func checkTagKeys(name string, tag reflect.StructTag) []string {
+	var problems []string
+	for key := range parseTag(tag) {
+		if _, ok := controllerTagKeys[key]; !ok {
+			problems = append(problems, fmt.Sprintf("%s: unknown tag %q", name, key))
 		}
 	}
+	sort.Strings(problems)
+	return problems
+}
+
+func mountRoute(r interface{}, adapter RouterAdapter, route *routeDefinition) {
+	api := route.api
+	if route.cover != nil {
+		urlCoverCache.Store(route.cover.key, route.cover)
+	}
+	adapter.Handle(api.Method, api.Path, generateApi(route.controller, route.fieldName, api, route.method, route.information))
+	scope, basePath := routeScope(r)
+	if registeredRoutes[scope] == nil {
+		registeredRoutes[scope] = map[string]string{}
+	}
+	registeredRoutes[scope][api.Method+" "+joinRoutePath(basePath, api.Path)] = route.String()
+}
+
+// 路由表的归属和前缀，gin的分组归属到根engine，其他router按自身区分
+func routeScope(r interface{}) (interface{}, string) {
+	var group *gin.RouterGroup
+	switch v := r.(type) {
+	case *gin.Engine:
+		group = &v.RouterGroup
+	case *gin.RouterGroup:
+		group = v
+	default:
+		return r, ""
+	}
+	if engine := reflect.ValueOf(group).Elem().FieldByName("engine"); engine.IsValid() && !engine.IsNil() {
+		return engine.Pointer(), group.BasePath()
+	}
+	return group, group.BasePath()
+}
 
+// 与gin拼接分组路径的规则一致
+func joinRoutePath(basePath, relativePath string) string {
+	if basePath == "" || relativePath == "" {
+		return basePath + relativePath
+	}
+	joined := path.Join(basePath, relativePath)
+	if strings.HasSuffix(relativePath, "/") && !strings.HasSuffix(joined, "/") {
+		joined += "/"
+	}
+	return joined
+}
+
+var (
+	txType             = reflect.TypeOf((*sql.Tx)(nil))
+	dbType             = reflect.TypeOf((*sql.DB)(nil))
+	ginContextType     = reflect.TypeOf((*gin.Context)(nil))
+	contextType        = reflect.TypeOf((*Context)(nil))
+	requestType        = reflect.TypeOf((*http.Request)(nil))
+	responseWriterType = reflect.TypeOf((*http.ResponseWriter)(nil)).Elem()
+)
+
+// 由框架注入，不需要在params中声明的参数
+func isContextParam(t reflect.Type) bool {
+	return t == txType || t == dbType || t == ginContextType || t == contextType || t == requestType || t == responseWriterType || t == goContextType
+}
+
+// 参数类型是否可以从请求中提取，与extractParamFromContext保持一致
+func isSupportedParam(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return isSupportedParam(t.Elem())
+	case reflect.Map, reflect.Struct, reflect.String, reflect.Int, reflect.Int64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	default:
+		return false
+	}
+}
+
+// 解析tag，规则同reflect.StructTag
+func parseTag(tag reflect.StructTag) map[string]string {
+	tagMap := make(map[string]string)
+	s := string(tag)
+	for s != "" {
+		// 跳过空白
+		i := 0
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		s = s[i:]
+		if s == "" {
+			break
+		}
+		i = 0
+		for i < len(s) && s[i] > ' ' && s[i] != ':' && s[i] != '"' && s[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(s) || s[i] != ':' || s[i+1] != '"' {
+			break
+		}
+		key := s[:i]
+		s = s[i+1:]
+		// 找到结束的引号
+		i = 1
+		for i < len(s) && s[i] != '"' {
+			if s[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(s) {
+			break
+		}
+		value, err := strconv.Unquote(s[:i+1])
+		if err != nil {
+			break
+		}
+		tagMap[key] = value
+		s = s[i+1:]
+	}
+	return tagMap
 }
 
-func generateApi(controllerDefinition *controllerDefinition, methodName string, api *requestMapping, method reflect.Value, controllerInformation *ControllerInformation) gin.HandlerFunc {
+func generateApi(controllerDefinition *controllerDefinition, methodName string, api *requestMapping, method reflect.Value, controllerInformation *ControllerInformation) func(HttpContext) {
 	// methodFullName := fmt.Sprintf("%s.%s", controllerDefinition.Name, methodName)
 	// fmt.Println("current method name is ", methodFullName)
-	return func(c *gin.Context) {
+	return func(c HttpContext) {
 		// 根据params继续拼接参数
 		// 获取method参数表
 		methodType := method.Type()
@@ -159,8 +459,15 @@ func generateApi(controllerDefinition *controllerDefinition, methodName string,
 		aopContext := newAopContext(numIn)
 		// aopContext.ControllerInformation = controllerDefinition
 		// aopContext.MethodInformation = api
-		aopContext.GinContext = c
+		aopContext.HttpContext = c
+		if gc, ok := c.(*ginContext); ok {
+			aopContext.GinContext = gc.c
+		}
 		aopContext.ControllerInformation = controllerInformation
+		// 请求结束时清理所有ThreadLocal中的值
+		closeScope := openThreadLocalScope()
+		defer closeScope()
+		defer currentContext.store.swap(aopContext)()
 		aopContext.Fn = func() {
 
 			// 公共参数注入
@@ -183,11 +490,7 @@ func generateApi(controllerDefinition *controllerDefinition, methodName string,
 					pi = api.Params[i]
 				}
 				if pi == nil {
-					if api.Method == "GET" {
-						pi = &paramItem{ParamName: "args" + strconv.Itoa(i), From: "query"}
-					} else {
-						pi = &paramItem{ParamName: "args" + strconv.Itoa(i), From: "json"}
-					}
+					pi = &paramItem{ParamName: "args" + strconv.Itoa(i), From: defaultParamFrom(api.Method)}
 				}
 				// 如果有公共参数，则优先使用公共参数
 				if value, ok := commonParams[pi.ParamName]; ok {
@@ -201,7 +504,11 @@ func generateApi(controllerDefinition *controllerDefinition, methodName string,
 			// 转换成reflect.Value
 			reflectArguments := make([]reflect.Value, len(aopContext.Arguments))
 			for i, arg := range aopContext.Arguments {
-				reflectArguments[i] = reflect.ValueOf(arg)
+				if arg == nil {
+					reflectArguments[i] = reflect.Zero(methodType.In(i))
+				} else {
+					reflectArguments[i] = reflect.ValueOf(arg)
+				}
 			}
 			res := method.Call(reflectArguments)
 			aopContext.Result = make([]interface{}, len(res))
@@ -228,54 +535,105 @@ func generateApi(controllerDefinition *controllerDefinition, methodName string,
 		}
 
 		//aopContext.Fn()
-		fn()
-
-		if aopContext.AutoReturn {
-			res := aopContext.Result
-			if len(res) > 0 {
-				c.JSON(200, res[0])
-			} else {
-				c.JSON(200, nil)
-			}
-		}
+		func() {
+			// 未被切面处理的异常交给错误处理器
+			defer func() {
+				if err := recover(); err != nil {
+					handleError(aopContext, err)
+				}
+			}()
+			fn()
+		}()
+
+		writeResult(aopContext)
 	}
 }
 
-func getStringFromContext(c *gin.Context, key string) string {
-	if strValue, ok := c.GetPostForm(key); ok {
-		return strValue
-	} else if strValue, ok := c.GetQuery(key); ok {
-		return strValue
+// 没有声明来源的参数，默认GET请求从query中获取参数，其他请求从body中获取参数
+// 客户端使用相同的规则
+func defaultParamFrom(method string) string {
+	if method == "GET" {
+		return "query"
+	}
+	return "json"
+}
+
+func getStringFromContext(c HttpContext, pi *paramItem) string {
+	switch pi.From {
+	case "path":
+		return c.Param(pi.ParamName)
+	case "header":
+		return c.Request().Header.Get(pi.ParamName)
+	}
+	req := c.Request()
+	if req.PostForm == nil {
+		req.ParseMultipartForm(defaultMultipartMemory)
+	}
+	if values, ok := req.PostForm[pi.ParamName]; ok && len(values) > 0 {
+		return values[0]
+	} else if values, ok := req.URL.Query()[pi.ParamName]; ok && len(values) > 0 {
+		return values[0]
 	}
 	return ""
 }
 
+// 参数对应的数据源名字，没有同名的数据源时使用默认数据源
+func paramDBName(pi *paramItem) string {
+	if hasNamedDB(pi.ParamName) {
+		return pi.ParamName
+	}
+	return ""
+}
+
+// 与gin的默认值保持一致
+const defaultMultipartMemory = 32 << 20
+
 func extractParamFromContext(ctx *Context, methodParams reflect.Type, pi *paramItem) reflect.Value {
-	c := ctx.GinContext
+	c := ctx.HttpContext
+	req := c.Request()
 	switch methodParams.Kind() {
 	case reflect.Ptr:
 		// 有一些特殊值需要处理
-		if methodParams.AssignableTo(reflect.TypeOf((*sql.Tx)(nil))) {
-			tx, ok := c.Get("Vermouth:tx")
-			if ok {
+		switch methodParams {
+		case txType:
+			// 参数名与数据源同名时注入该数据源的事务，否则是默认数据源的事务
+			if tx, ok := c.Get(txKey("Vermouth:tx", paramDBName(pi))); ok {
 				return reflect.ValueOf(tx)
 			}
-		} else if methodParams.AssignableTo(reflect.TypeOf((*gin.Context)(nil))) {
-			return reflect.ValueOf(c)
+			return reflect.Zero(methodParams)
+		case dbType:
+			if db := GetNamedDB(paramDBName(pi)); db != nil {
+				return reflect.ValueOf(db)
+			}
+			return reflect.Zero(methodParams)
+		case ginContextType:
+			return reflect.ValueOf(ctx.GinContext)
+		case contextType:
+			return reflect.ValueOf(ctx)
+		case requestType:
+			return reflect.ValueOf(req)
 		}
 		elemValue := extractParamFromContext(ctx, methodParams.Elem(), pi)
 		ptrValue := reflect.New(methodParams.Elem())
 		ptrValue.Elem().Set(elemValue)
 		return ptrValue
 		// return extractParamFromContext(c, methodParams.Elem(), paramName)
+	case reflect.Interface:
+		switch methodParams {
+		case responseWriterType:
+			return reflect.ValueOf(c.Writer())
+		case goContextType:
+			return reflect.ValueOf(requestContext(ctx))
+		}
+		return reflect.Zero(methodParams)
 	case reflect.Map:
 		newMapValue := reflect.MakeMap(methodParams)
 		newMap := newMapValue.Interface()
-		if err := c.ShouldBindJSON(&newMap); err == nil {
+		if err := binding.JSON.Bind(req, &newMap); err == nil {
 			return newMapValue
 		}
 		queryMap := make(map[string]string)
-		if err := c.ShouldBindQuery(&queryMap); err == nil {
+		if err := binding.Query.Bind(req, &queryMap); err == nil {
 			for k, v := range queryMap {
 				newMapValue.SetMapIndex(reflect.ValueOf(k), reflect.ValueOf(v))
 			}
@@ -286,20 +644,20 @@ func extractParamFromContext(ctx *Context, methodParams reflect.Type, pi *paramI
 		newStructRef := newStructPtrRef.Elem()
 		var ve *ValidatorError
 		if pi.From == "json" {
-			if err := c.ShouldBindJSON(newStructPtrRef.Interface()); err != nil {
+			if err := binding.JSON.Bind(req, newStructPtrRef.Interface()); err != nil {
 				// 如果绑定失败，则返回一个空的结构体
 				if ers, ok := err.(validator.ValidationErrors); ok {
 					ve = makeValidatorError(newStructPtrRef.Interface(), ers)
 				}
 			}
 		} else if pi.From == "query" {
-			if err := c.ShouldBindQuery(newStructPtrRef.Interface()); err != nil {
+			if err := binding.Query.Bind(req, newStructPtrRef.Interface()); err != nil {
 				if ers, ok := err.(validator.ValidationErrors); ok {
 					ve = makeValidatorError(newStructPtrRef.Interface(), ers)
 				}
 			}
 		} else if pi.From == "form" {
-			if err := c.ShouldBind(newStructPtrRef.Interface()); err != nil {
+			if err := binding.Default(req.Method, contentType(req)).Bind(req, newStructPtrRef.Interface()); err != nil {
 				if ers, ok := err.(validator.ValidationErrors); ok {
 					ve = makeValidatorError(newStructPtrRef.Interface(), ers)
 				}
@@ -333,7 +691,7 @@ func extractParamFromContext(ctx *Context, methodParams reflect.Type, pi *paramI
 					vals := method.Func.Call([]reflect.Value{newStructPtrRef})
 					if len(vals) > 0 {
 						if err, ok := vals[0].Interface().(error); ok {
-							if ve == nil {	
+							if ve == nil {
 								ve = &ValidatorError{}
 							}
 							ve.ErrorMessages = append(ve.ErrorMessages, err.Error())
@@ -348,23 +706,23 @@ func extractParamFromContext(ctx *Context, methodParams reflect.Type, pi *paramI
 		}
 		return newStructRef
 	case reflect.String:
-		return reflect.ValueOf(getStringFromContext(c, pi.ParamName))
+		return reflect.ValueOf(getStringFromContext(c, pi))
 	case reflect.Int:
-		strValue := getStringFromContext(c, pi.ParamName)
+		strValue := getStringFromContext(c, pi)
 		if strValue != "" {
 			intValue, _ := strconv.Atoi(strValue)
 			return reflect.ValueOf(intValue)
 		}
 		return reflect.ValueOf(0)
 	case reflect.Int64:
-		strValue := getStringFromContext(c, pi.ParamName)
+		strValue := getStringFromContext(c, pi)
 		if strValue != "" {
 			intValue, _ := strconv.ParseInt(strValue, 10, 64)
 			return reflect.ValueOf(intValue)
 		}
 		return reflect.ValueOf(int64(0))
 	case reflect.Slice:
-		strValue := getStringFromContext(c, pi.ParamName)
+		strValue := getStringFromContext(c, pi)
 		if strValue != "" {
 			// 暂时先这样，后面再改
 			// 使用逗号拆分字符串
@@ -382,6 +740,15 @@ func extractParamFromContext(ctx *Context, methodParams reflect.Type, pi *paramI
 	}
 }
 
+// 去掉Content-Type中的参数部分，例如charset
+func contentType(req *http.Request) string {
+	ct := req.Header.Get("Content-Type")
+	if i := strings.IndexAny(ct, "; "); i >= 0 {
+		ct = ct[:i]
+	}
+	return ct
+}
+
 func getStructName(i interface{}) string {
 	t := reflect.TypeOf(i)
 	if t.Kind() == reflect.Ptr {
@@ -28,8 +28,15 @@ func Convert(src interface{}, dst interface{}) {
 	srcInfo := GetTypeInfo(srcType)
 	dstInfo := GetTypeInfo(dstType)
 
-	for _, fieldInfo := range srcInfo.Fields {
+	// 按声明顺序转换，嵌入的结构体先于其中提升的字段
+	for _, fieldInfo := range srcInfo.fieldList {
 		dstFieldInfo, ok := dstInfo.Fields[fieldInfo.Name]
+		if !ok {
+			// 字段名不同时按json标签匹配
+			if tag, has := fieldInfo.Tags["json"]; has {
+				dstFieldInfo, ok = dstInfo.FieldByTag("json", tag.Name)
+			}
+		}
 		if !ok {
 			continue
 		}
@@ -71,7 +78,8 @@ func Convert(src interface{}, dst interface{}) {
 			if err != nil {
 				continue
 			}
-			fieldInfo.Set(dst, fieldValue)
+			// 类型不一致的字段不转换
+			dstFieldInfo.Set(dst, fieldValue)
 		}
 	}
 
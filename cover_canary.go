@@ -0,0 +1,238 @@
+package vermouth
+
+import (
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// CoverShadow 默认的模式，只比较，不影响线上的结果
+	CoverShadow = "shadow"
+	// CoverCanary 新接口按比例处理线上的请求，旧接口在后台比较
+	CoverCanary = "canary"
+
+	// CoverHealthy 金丝雀发布正常
+	CoverHealthy = "healthy"
+	// CoverRolledBack 不一致或者出错的比例超过阈值，已经自动回滚
+	CoverRolledBack = "rolled_back"
+)
+
+// CoverCanaryConfig 金丝雀发布时自动回滚的条件
+type CoverCanaryConfig struct {
+	// 统计的滑动窗口，默认1分钟
+	Window time.Duration
+	// 窗口内的比较次数达到该值时才会判断，默认20
+	MinRequests int64
+	// 不一致的比例超过该值时回滚，默认0.05
+	MaxMismatchRate float64
+	// 新接口出错的比例超过该值时回滚，默认0.01，转发失败或者状态码为5xx都算出错
+	MaxErrorRate float64
+	// 回滚后调用
+	OnRollback func(info CoverRouteInfo)
+}
+
+func (config *CoverCanaryConfig) setDefaults() {
+	if config.Window <= 0 {
+		config.Window = time.Minute
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = 20
+	}
+	if config.MaxMismatchRate <= 0 {
+		config.MaxMismatchRate = 0.05
+	}
+	if config.MaxErrorRate <= 0 {
+		config.MaxErrorRate = 0.01
+	}
+}
+
+// CoverWindowStats 滑动窗口内的统计
+type CoverWindowStats struct {
+	Total      int64 `json:"total"`
+	Mismatched int64 `json:"mismatched"`
+	Failed     int64 `json:"failed"`
+}
+
+// CoverRouteInfo 渐进式覆盖的接口当前的状态
+type CoverRouteInfo struct {
+	// 请求到达的路径，即cover_url，有cover_target时为接口本身的路径
+	Route  string `json:"route"`
+	Path   string `json:"path"`
+	Target string `json:"target,omitempty"`
+	Mode   string `json:"mode"`
+	// 新接口处理的线上请求的百分比，只有canary模式有值
+	Percent float64 `json:"percent"`
+	// 只有canary模式有值
+	Health       string           `json:"health,omitempty"`
+	Reason       string           `json:"reason,omitempty"`
+	RolledBackAt time.Time        `json:"rolled_back_at"`
+	Window       CoverWindowStats `json:"window"`
+}
+
+// 比较的结果
+type coverOutcome int
+
+const (
+	coverMatched coverOutcome = iota
+	coverMismatched
+	coverFailed
+)
+
+// 滑动窗口分成的桶数
+const coverBuckets = 10
+
+type coverBucket struct {
+	start int64
+	stats CoverWindowStats
+}
+
+// 金丝雀发布的状态，同一个接口在所有的CoverUrl之间共享
+type coverCanary struct {
+	mu           sync.Mutex
+	percent      float64
+	health       string
+	reason       string
+	rolledBackAt time.Time
+	// 每个桶的时间宽度，第一次记录时由CoverCanaryConfig.Window决定
+	width   time.Duration
+	buckets [coverBuckets]coverBucket
+}
+
+func newCoverCanary(percent float64) *coverCanary {
+	return &coverCanary{percent: percent, health: CoverHealthy}
+}
+
+// 请求是否交给转发的目标处理
+// 没有cover_target时，按比例交给新接口处理；否则本接口就是新接口，剩下的比例交给远程的旧接口处理
+func (canary *coverCanary) swap(remote bool) bool {
+	canary.mu.Lock()
+	percent := canary.percent
+	canary.mu.Unlock()
+	chosen := percent >= 100 || percent > 0 && mathrand.Float64()*100 < percent
+	return chosen != remote
+}
+
+// 记录一次结果，超过阈值时回滚，返回是否刚刚回滚
+func (canary *coverCanary) record(outcome coverOutcome, config CoverCanaryConfig) bool {
+	now := time.Now()
+	canary.mu.Lock()
+	defer canary.mu.Unlock()
+	canary.width = config.Window / coverBuckets
+	if canary.width <= 0 {
+		canary.width = time.Millisecond
+	}
+	start := now.UnixNano() / int64(canary.width) * int64(canary.width)
+	bucket := &canary.buckets[start/int64(canary.width)%coverBuckets]
+	if bucket.start != start {
+		*bucket = coverBucket{start: start}
+	}
+	bucket.stats.Total++
+	switch outcome {
+	case coverMismatched:
+		bucket.stats.Mismatched++
+	case coverFailed:
+		bucket.stats.Failed++
+	}
+	if canary.health != CoverHealthy || canary.percent <= 0 {
+		return false
+	}
+	stats := canary.window(now)
+	if stats.Total < config.MinRequests {
+		return false
+	}
+	if rate := float64(stats.Failed) / float64(stats.Total); rate > config.MaxErrorRate {
+		canary.reason = fmt.Sprintf("error rate %s exceeds %s", formatRate(rate), formatRate(config.MaxErrorRate))
+	} else if rate = float64(stats.Mismatched) / float64(stats.Total); rate > config.MaxMismatchRate {
+		canary.reason = fmt.Sprintf("mismatch rate %s exceeds %s", formatRate(rate), formatRate(config.MaxMismatchRate))
+	} else {
+		return false
+	}
+	canary.percent, canary.health, canary.rolledBackAt = 0, CoverRolledBack, now
+	return true
+}
+
+// 需要持有锁
+func (canary *coverCanary) window(now time.Time) CoverWindowStats {
+	var stats CoverWindowStats
+	if canary.width <= 0 {
+		return stats
+	}
+	oldest := now.UnixNano() - int64(canary.width)*coverBuckets
+	for _, bucket := range canary.buckets {
+		if bucket.start > oldest {
+			stats.Total += bucket.stats.Total
+			stats.Mismatched += bucket.stats.Mismatched
+			stats.Failed += bucket.stats.Failed
+		}
+	}
+	return stats
+}
+
+func (route *coverRoute) info() CoverRouteInfo {
+	info := CoverRouteInfo{Route: route.key, Path: route.Path, Target: route.Target, Mode: CoverShadow}
+	if canary := route.canary; canary != nil {
+		canary.mu.Lock()
+		info.Mode, info.Percent, info.Health = CoverCanary, canary.percent, canary.health
+		info.Reason, info.RolledBackAt, info.Window = canary.reason, canary.rolledBackAt, canary.window(time.Now())
+		canary.mu.Unlock()
+	}
+	return info
+}
+
+// 记录金丝雀发布的结果，回滚时记录日志并通知
+func (cu *CoverUrl) recordCanary(route *coverRoute, outcome coverOutcome) {
+	if route.canary == nil || !route.canary.record(outcome, cu.config.Canary) {
+		return
+	}
+	info := route.info()
+	log.Printf("vermouth: cover canary %s rolled back: %s", route.key, info.Reason)
+	if cu.config.Canary.OnRollback != nil {
+		cu.config.Canary.OnRollback(info)
+	}
+}
+
+// CoverRoutes 所有渐进式覆盖的接口当前的状态，按路径排序
+func CoverRoutes() []CoverRouteInfo {
+	var infos []CoverRouteInfo
+	urlCoverCache.Range(func(key, value interface{}) bool {
+		infos = append(infos, value.(*coverRoute).info())
+		return true
+	})
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Route < infos[j].Route
+	})
+	return infos
+}
+
+// LookupCoverRoute 获取单个接口的状态，route为请求到达的路径
+func LookupCoverRoute(route string) (CoverRouteInfo, bool) {
+	value, ok := urlCoverCache.Load(route)
+	if !ok {
+		return CoverRouteInfo{}, false
+	}
+	return value.(*coverRoute).info(), true
+}
+
+// SetCoverPercent 调整新接口处理的线上请求的百分比，同时清空窗口内的统计，回滚后可以用来重新开始
+func SetCoverPercent(route string, percent float64) error {
+	value, ok := urlCoverCache.Load(route)
+	if !ok {
+		return fmt.Errorf("cover route %s not found", route)
+	}
+	canary := value.(*coverRoute).canary
+	if canary == nil {
+		return fmt.Errorf("cover route %s is not in canary mode", route)
+	}
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("invalid cover percent %v, must be between 0 and 100", percent)
+	}
+	canary.mu.Lock()
+	defer canary.mu.Unlock()
+	canary.percent, canary.health, canary.reason, canary.rolledBackAt = percent, CoverHealthy, "", time.Time{}
+	canary.buckets = [coverBuckets]coverBucket{}
+	return nil
+}
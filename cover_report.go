@@ -0,0 +1,334 @@
+package vermouth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CoverLog 渐进式覆盖写入的一条不一致日志
+type CoverLog struct {
+	// 日志文件的路径，NDJSON文件为 文件名:行号
+	File string `json:"-"`
+	CoverMismatch
+}
+
+// LoadCoverLogs 读取日志目录中所有的不一致日志，包括RotatingFileSink写入的.ndjson文件，按时间排序
+func LoadCoverLogs(dir string) ([]*CoverLog, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var logs []*CoverLog
+	for _, file := range files {
+		if filepath.Base(file) == coverStatsFile {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		coverLog := &CoverLog{File: file}
+		if err = json.Unmarshal(data, coverLog); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", file, err)
+		}
+		logs = append(logs, coverLog)
+	}
+	if files, err = filepath.Glob(filepath.Join(dir, "*.ndjson")); err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for i, line := range bytes.Split(data, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			coverLog := &CoverLog{File: fmt.Sprintf("%s:%d", file, i+1)}
+			if err = json.Unmarshal(line, coverLog); err != nil {
+				return nil, fmt.Errorf("parse %s: %w", coverLog.File, err)
+			}
+			logs = append(logs, coverLog)
+		}
+	}
+	for _, coverLog := range logs {
+		// 兼容旧的日志，按请求的路径统计，没有diff时重新计算
+		if coverLog.Route == "" {
+			if u, err := url.Parse(coverLog.Request.URL); err == nil {
+				coverLog.Route = u.Path
+			}
+		}
+		if coverLog.Diff == nil {
+			coverLog.Diff = DiffJSON([]byte(coverLog.OriginalResponse), []byte(coverLog.DuplicateResponse), coverLog.Options)
+		}
+	}
+	sort.SliceStable(logs, func(i, j int) bool {
+		return logs[i].Time.Before(logs[j].Time)
+	})
+	return logs, nil
+}
+
+// CoverReport 按接口汇总的不一致报告
+type CoverReport struct {
+	Dir       string              `json:"dir"`
+	Generated time.Time           `json:"generated"`
+	Routes    []*CoverRouteReport `json:"routes"`
+}
+
+// CoverRouteReport 单个接口的不一致情况
+type CoverRouteReport struct {
+	Route  string `json:"route"`
+	Target string `json:"target,omitempty"`
+	// 日志目录中cover_stats.json记录的统计，没有时为零值
+	Stats CoverUrlStats `json:"stats"`
+	// 不一致的日志数
+	Mismatched int `json:"mismatched"`
+	// 不一致的比例，没有统计时为-1
+	Rate   float64           `json:"rate"`
+	Groups []*CoverDiffGroup `json:"groups"`
+}
+
+// CoverDiffGroup 差异相同的一组日志，数组下标不参与分组
+type CoverDiffGroup struct {
+	Signature string   `json:"signature"`
+	Count     int      `json:"count"`
+	Paths     []string `json:"paths"`
+	// 最多保留3个示例文件
+	Examples []string `json:"examples"`
+}
+
+// BuildCoverReport 汇总日志目录，计算每个接口的不一致比例，并对相似的差异分组
+func BuildCoverReport(dir string) (*CoverReport, error) {
+	logs, err := LoadCoverLogs(dir)
+	if err != nil {
+		return nil, err
+	}
+	stats := map[string]CoverUrlStats{}
+	if data, err := os.ReadFile(filepath.Join(dir, coverStatsFile)); err == nil {
+		if err = json.Unmarshal(data, &stats); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", coverStatsFile, err)
+		}
+	}
+	report := &CoverReport{Dir: dir, Generated: time.Now()}
+	routes := map[string]*CoverRouteReport{}
+	groups := map[string]map[string]*CoverDiffGroup{}
+	route := func(name string) *CoverRouteReport {
+		r, ok := routes[name]
+		if !ok {
+			r = &CoverRouteReport{Route: name, Stats: stats[name], Rate: -1}
+			routes[name] = r
+			groups[name] = map[string]*CoverDiffGroup{}
+			report.Routes = append(report.Routes, r)
+		}
+		return r
+	}
+	for name := range stats {
+		route(name)
+	}
+	for _, coverLog := range logs {
+		r := route(coverLog.Route)
+		r.Target = coverLog.Target
+		r.Mismatched++
+		paths := diffSignature(coverLog.Diff)
+		signature := strings.Join(paths, ", ")
+		group, ok := groups[r.Route][signature]
+		if !ok {
+			group = &CoverDiffGroup{Signature: signature, Paths: paths}
+			groups[r.Route][signature] = group
+			r.Groups = append(r.Groups, group)
+		}
+		group.Count++
+		if len(group.Examples) < 3 {
+			group.Examples = append(group.Examples, coverLog.File)
+		}
+	}
+	for _, r := range report.Routes {
+		if compared := r.Stats.Matched + r.Stats.Mismatched; compared > 0 {
+			r.Rate = float64(r.Stats.Mismatched) / float64(compared)
+		}
+		sort.SliceStable(r.Groups, func(i, j int) bool {
+			return r.Groups[i].Count > r.Groups[j].Count
+		})
+	}
+	sort.SliceStable(report.Routes, func(i, j int) bool {
+		if report.Routes[i].Mismatched != report.Routes[j].Mismatched {
+			return report.Routes[i].Mismatched > report.Routes[j].Mismatched
+		}
+		return report.Routes[i].Route < report.Routes[j].Route
+	})
+	return report, nil
+}
+
+// 差异的特征，数组下标替换为*，去重后排序
+func diffSignature(diffs []JSONDiff) []string {
+	seen := map[string]struct{}{}
+	var paths []string
+	for _, diff := range diffs {
+		segments := strings.Split(diff.Path, ".")
+		for i, segment := range segments {
+			if _, err := strconv.Atoi(segment); err == nil {
+				segments[i] = "*"
+			}
+		}
+		path := diff.Kind + " " + strings.Join(segments, ".")
+		if diff.Path == "" {
+			path = diff.Kind + " (root)"
+		}
+		if _, ok := seen[path]; !ok {
+			seen[path] = struct{}{}
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func formatRate(rate float64) string {
+	if rate < 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%", rate*100)
+}
+
+// Markdown 生成Markdown格式的报告
+func (r *CoverReport) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# cover_url 不一致报告\n\n日志目录：%s，生成时间：%s\n\n", r.Dir, r.Generated.Format("2006-01-02 15:04:05"))
+	b.WriteString("| 接口 | 目标 | 比较次数 | 不一致 | 不一致率 | 失败 | 丢弃 |\n|---|---|---|---|---|---|---|\n")
+	for _, route := range r.Routes {
+		fmt.Fprintf(&b, "| %s | %s | %d | %d | %s | %d | %d |\n", route.Route, route.Target,
+			route.Stats.Matched+route.Stats.Mismatched, route.Mismatched, formatRate(route.Rate), route.Stats.Failed, route.Stats.Dropped)
+	}
+	for _, route := range r.Routes {
+		if len(route.Groups) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n## %s\n\n| 次数 | 差异 | 示例 |\n|---|---|---|\n", route.Route)
+		for _, group := range route.Groups {
+			fmt.Fprintf(&b, "| %d | %s | %s |\n", group.Count, strings.Join(group.Paths, "<br>"), strings.Join(group.Examples, "<br>"))
+		}
+	}
+	return b.String()
+}
+
+var coverReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"rate": formatRate,
+	"compared": func(s CoverUrlStats) int64 {
+		return s.Matched + s.Mismatched
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>cover_url 不一致报告</title>
+<style>
+body { font-family: sans-serif; margin: 24px; }
+table { border-collapse: collapse; margin-bottom: 24px; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+</style>
+</head>
+<body>
+<h1>cover_url 不一致报告</h1>
+<p>日志目录：{{.Dir}}，生成时间：{{.Generated.Format "2006-01-02 15:04:05"}}</p>
+<table>
+<tr><th>接口</th><th>目标</th><th>比较次数</th><th>不一致</th><th>不一致率</th><th>失败</th><th>丢弃</th></tr>
+{{range .Routes}}<tr><td>{{.Route}}</td><td>{{.Target}}</td><td>{{compared .Stats}}</td><td>{{.Mismatched}}</td><td>{{rate .Rate}}</td><td>{{.Stats.Failed}}</td><td>{{.Stats.Dropped}}</td></tr>
+{{end}}</table>
+{{range .Routes}}{{if .Groups}}<h2>{{.Route}}</h2>
+<table>
+<tr><th>次数</th><th>差异</th><th>示例</th></tr>
+{{range .Groups}}<tr><td>{{.Count}}</td><td>{{range .Paths}}{{.}}<br>{{end}}</td><td>{{range .Examples}}{{.}}<br>{{end}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}</body>
+</html>
+`))
+
+// HTML 生成HTML格式的报告
+func (r *CoverReport) HTML() string {
+	var b strings.Builder
+	if err := coverReportTemplate.Execute(&b, r); err != nil {
+		return err.Error()
+	}
+	return b.String()
+}
+
+// CoverReplayResult 重放一条日志的结果
+type CoverReplayResult struct {
+	Log      *CoverLog
+	Status   int
+	Response string
+	// 与期望的结果之间的差异
+	Diff []JSONDiff
+	Err  error
+}
+
+// Fixed 重放的结果与期望的结果一致
+func (r *CoverReplayResult) Fixed() bool {
+	return r.Err == nil && len(r.Diff) == 0
+}
+
+// ReplayCoverLogs 将日志中的请求重新发送到baseURL，确认修复后的接口与旧接口的结果是否一致
+// 没有cover_target时重放到新接口，与原请求的结果比较；否则重放到本服务的接口，与远程服务的结果比较
+func ReplayCoverLogs(logs []*CoverLog, baseURL string, client *http.Client) []*CoverReplayResult {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	baseURL = strings.TrimRight(baseURL, "/")
+	results := make([]*CoverReplayResult, 0, len(logs))
+	for _, coverLog := range logs {
+		result := &CoverReplayResult{Log: coverLog}
+		results = append(results, result)
+		path, expected := coverLog.Route, coverLog.DuplicateResponse
+		if coverLog.Target == "" {
+			u, err := url.Parse(coverLog.Request.URL)
+			if err != nil {
+				result.Err = err
+				continue
+			}
+			path, expected = u.Path, coverLog.OriginalResponse
+		}
+		target := baseURL + path
+		if coverLog.Request.Query != "" {
+			target += "?" + coverLog.Request.Query
+		}
+		request, err := http.NewRequest(coverLog.Request.Method, target, bytes.NewBufferString(coverLog.Request.Body))
+		if err != nil {
+			result.Err = err
+			continue
+		}
+		for name, values := range coverLog.Request.Headers {
+			// 脱敏的header没有原始值，需要时通过client的Transport设置
+			if len(values) == 1 && values[0] == RedactedHeaderValue {
+				continue
+			}
+			request.Header[name] = values
+		}
+		resp, err := client.Do(request)
+		if err != nil {
+			result.Err = err
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			result.Err = err
+			continue
+		}
+		result.Status = resp.StatusCode
+		result.Response = string(body)
+		result.Diff = DiffJSON([]byte(expected), body, coverLog.Options)
+	}
+	return results
+}
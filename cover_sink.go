@@ -0,0 +1,362 @@
+package vermouth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CoverMismatch 一次结果不一致的比较，写入日志时的格式
+type CoverMismatch struct {
+	Request CoverRequest `json:"request"`
+	// 接口的cover_url，有cover_target时为接口本身的路径
+	Route   string          `json:"route"`
+	Target  string          `json:"target"`
+	Time    time.Time       `json:"time"`
+	Options JSONDiffOptions `json:"options"`
+	// 两边的响应体，为了兼容旧的日志单独存放
+	OriginalResponse  string        `json:"original_response"`
+	DuplicateResponse string        `json:"duplicate_response"`
+	Original          CoverResponse `json:"original"`
+	Duplicate         CoverResponse `json:"duplicate"`
+	Diff              []JSONDiff    `json:"diff"`
+}
+
+// CoverRequest 转发的请求
+type CoverRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body"`
+	Query   string      `json:"query"`
+}
+
+// CoverResponse 响应的状态码、header和耗时，响应体在CoverMismatch中
+type CoverResponse struct {
+	Status  int           `json:"status"`
+	Headers http.Header   `json:"headers"`
+	Latency time.Duration `json:"latency"`
+}
+
+// MismatchSink 接收结果不一致的比较，会被多个worker同时调用
+type MismatchSink interface {
+	Write(event *CoverMismatch) error
+	// CoverUrl.Close时调用
+	Close() error
+}
+
+// MismatchSinkFunc 使用函数接收不一致的比较，需要自己处理并发
+type MismatchSinkFunc func(event *CoverMismatch) error
+
+func (f MismatchSinkFunc) Write(event *CoverMismatch) error {
+	return f(event)
+}
+
+func (f MismatchSinkFunc) Close() error {
+	return nil
+}
+
+type dirSink struct {
+	dir string
+}
+
+// NewDirSink 每次不一致写入目录中的一个文件，没有配置Sinks时的默认行为
+func NewDirSink(dir string) MismatchSink {
+	// 如果没有该目录则创建
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		os.MkdirAll(dir, 0755)
+	}
+	return &dirSink{dir: dir}
+}
+
+func (s *dirSink) Write(event *CoverMismatch) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	// 按时间戳和uuid写入新的文件
+	filename := filepath.Join(s.dir, fmt.Sprintf("%s_%s.json", event.Time.Format("2006-01-02_15-04-05"), uuid()))
+	return os.WriteFile(filename, data, 0644)
+}
+
+func (s *dirSink) Close() error {
+	return nil
+}
+
+// RotatingFileConfig 按大小和时间轮转的NDJSON文件
+type RotatingFileConfig struct {
+	// 当前写入的文件，例如 logs/cover.ndjson，轮转后的文件为 logs/cover-20060102T150405.000.ndjson
+	Filename string
+	// 文件超过该大小时轮转，单位字节，默认100MB
+	MaxSize int64
+	// 文件打开超过该时间时轮转，默认不按时间轮转
+	MaxAge time.Duration
+	// 保留的历史文件数，默认全部保留
+	MaxBackups int
+}
+
+// RotatingFileSink 每次不一致写入一行json，按大小和时间轮转
+type RotatingFileSink struct {
+	config RotatingFileConfig
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+	// 调用Close之后不再写入，file为nil但没有关闭时下次写入会重新打开
+	closed bool
+}
+
+// 历史文件名中的时间格式
+const backupTimeFormat = "20060102T150405.000"
+
+// NewRotatingFileSink 打开或者创建文件，已有的内容会保留
+func NewRotatingFileSink(config RotatingFileConfig) (*RotatingFileSink, error) {
+	if config.Filename == "" {
+		return nil, fmt.Errorf("rotating file sink: empty filename")
+	}
+	if config.MaxSize <= 0 {
+		config.MaxSize = 100 << 20
+	}
+	s := &RotatingFileSink{config: config}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *RotatingFileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.config.Filename), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.config.Filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file, s.size, s.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+func (s *RotatingFileSink) Write(event *CoverMismatch) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return os.ErrClosed
+	}
+	// 之前轮转失败并且没能重新打开时，重新打开当前文件
+	if s.file == nil {
+		if err = s.open(); err != nil {
+			return err
+		}
+	}
+	// 空文件不轮转，避免单条日志超过MaxSize时一直轮转
+	var rotateErr error
+	if s.size > 0 && (s.size+int64(len(data)) > s.config.MaxSize ||
+		s.config.MaxAge > 0 && time.Since(s.opened) >= s.config.MaxAge) {
+		// 轮转失败时继续写入当前文件，下次写入时再次轮转
+		if rotateErr = s.rotate(); s.file == nil {
+			return rotateErr
+		}
+	}
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return rotateErr
+}
+
+// Rotate 立即轮转当前文件
+func (s *RotatingFileSink) Rotate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return os.ErrClosed
+	}
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	return s.rotate()
+}
+
+// 轮转当前文件，失败时重新打开当前文件继续写入
+func (s *RotatingFileSink) rotate() error {
+	err := s.file.Close()
+	s.file = nil
+	if err == nil {
+		err = s.backup()
+	}
+	if openErr := s.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+// 将当前文件重命名为历史文件，并删除超过MaxBackups的历史文件
+func (s *RotatingFileSink) backup() error {
+	ext := filepath.Ext(s.config.Filename)
+	prefix := strings.TrimSuffix(s.config.Filename, ext) + "-"
+	now := time.Now().Format(backupTimeFormat)
+	backup := prefix + now + ext
+	// 同一毫秒内多次轮转时加上序号
+	for i := 1; ; i++ {
+		if _, err := os.Stat(backup); os.IsNotExist(err) {
+			break
+		}
+		backup = fmt.Sprintf("%s%s-%d%s", prefix, now, i, ext)
+	}
+	if err := os.Rename(s.config.Filename, backup); err != nil {
+		return err
+	}
+	if s.config.MaxBackups > 0 {
+		s.prune(prefix, ext)
+	}
+	return nil
+}
+
+type backupFile struct {
+	name string
+	time time.Time
+	seq  int
+}
+
+// 按文件名中的时间和序号排序，删除最早的历史文件
+func (s *RotatingFileSink) prune(prefix, ext string) {
+	names, _ := filepath.Glob(prefix + "*" + ext)
+	var backups []backupFile
+	for _, name := range names {
+		if backup, ok := parseBackup(name, prefix, ext); ok {
+			backups = append(backups, backup)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].time.Equal(backups[j].time) {
+			return backups[i].time.Before(backups[j].time)
+		}
+		return backups[i].seq < backups[j].seq
+	})
+	for len(backups) > s.config.MaxBackups {
+		os.Remove(backups[0].name)
+		backups = backups[1:]
+	}
+}
+
+// 解析历史文件名中的时间和序号，格式为 文件名-时间[-序号].扩展名
+func parseBackup(name, prefix, ext string) (backupFile, bool) {
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+		return backupFile{}, false
+	}
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+	seq := 0
+	if i := strings.IndexByte(stamp, '-'); i >= 0 {
+		n, err := strconv.Atoi(stamp[i+1:])
+		if err != nil || n <= 0 {
+			return backupFile{}, false
+		}
+		stamp, seq = stamp[:i], n
+	}
+	t, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+	if err != nil {
+		return backupFile{}, false
+	}
+	return backupFile{name: name, time: t, seq: seq}, true
+}
+
+func (s *RotatingFileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// MemorySink 在内存中保留最近的不一致，主要用于测试
+type MemorySink struct {
+	mu     sync.Mutex
+	events []*CoverMismatch
+	// 下一次写入的位置
+	next  int
+	full  bool
+	total int64
+}
+
+// NewMemorySink 最多保留capacity条，超过时覆盖最早的
+func NewMemorySink(capacity int) *MemorySink {
+	if capacity <= 0 {
+		capacity = 100
+	}
+	return &MemorySink{events: make([]*CoverMismatch, capacity)}
+}
+
+func (s *MemorySink) Write(event *CoverMismatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events[s.next] = event
+	s.next = (s.next + 1) % len(s.events)
+	if s.next == 0 {
+		s.full = true
+	}
+	s.total++
+	return nil
+}
+
+func (s *MemorySink) Close() error {
+	return nil
+}
+
+// Events 按写入的顺序返回保留的不一致
+func (s *MemorySink) Events() []*CoverMismatch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.full {
+		return append([]*CoverMismatch(nil), s.events[:s.next]...)
+	}
+	return append(append([]*CoverMismatch(nil), s.events[s.next:]...), s.events[:s.next]...)
+}
+
+// Len 保留的不一致数
+func (s *MemorySink) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.full {
+		return len(s.events)
+	}
+	return s.next
+}
+
+// Total 写入过的不一致数，包括已经被覆盖的
+func (s *MemorySink) Total() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.total
+}
+
+// Reset 清空保留的不一致
+func (s *MemorySink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = make([]*CoverMismatch, len(s.events))
+	s.next, s.full, s.total = 0, false, 0
+}
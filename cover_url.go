@@ -2,15 +2,24 @@ package vermouth
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"io"
+	"log"
+	mathrand "math/rand"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,112 +33,746 @@ func (w bodyWriter) Write(b []byte) (int, error) {
 	return w.ResponseWriter.Write(b)
 }
 
+func (w bodyWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// 被覆盖的接口，key为cover_url
 var urlCoverCache = sync.Map{}
 
+// 覆盖关系和比较结果时的选项
+type coverRoute struct {
+	// 在urlCoverCache中的key，即需要转发的请求的路径
+	key string
+	// 转发的路径，没有Target时为新接口的路径，否则为远程服务上的cover_url
+	Path string
+	// 远程服务的地址，或者CoverUrlConfig.Targets中的名字
+	Target string
+	Diff   JSONDiffOptions
+	// 采样率，小于0时使用全局配置
+	SampleRate float64
+	// 允许转发的请求方法，为空时使用全局配置
+	Methods []string
+	// 是否在总是回滚的事务中执行转发的请求，为nil时使用全局配置
+	Rollback *bool
+	// canary模式时才有值
+	canary *coverCanary
+}
+
+// 解析cover_*相关的tag，例如 cover_ignore:"data.createdAt,traceId" cover_tolerance:"0.001" cover_unordered:"data.items"
+func parseCoverRoute(path string, attributes map[string]string) (*coverRoute, []string) {
+	var problems []string
+	route := &coverRoute{key: attributes["cover_url"], Path: path, SampleRate: -1}
+	// 有cover_target时，请求本接口时转发到远程服务的cover_url，用于和旧系统比较
+	if target := attributes["cover_target"]; target != "" {
+		route.key, route.Path, route.Target = path, attributes["cover_url"], strings.TrimRight(target, "/")
+	}
+	route.Diff.Ignore = splitTagList(attributes["cover_ignore"])
+	if tolerance := attributes["cover_tolerance"]; tolerance != "" {
+		value, err := strconv.ParseFloat(tolerance, 64)
+		if err != nil || value < 0 {
+			problems = append(problems, fmt.Sprintf("invalid cover_tolerance %q", tolerance))
+		}
+		route.Diff.Tolerance = value
+	}
+	// true表示所有的数组都不关心顺序
+	if unordered := attributes["cover_unordered"]; unordered == "true" {
+		route.Diff.Unordered = []string{"*"}
+	} else {
+		route.Diff.Unordered = splitTagList(unordered)
+	}
+	if sample := attributes["cover_sample"]; sample != "" {
+		value, err := strconv.ParseFloat(sample, 64)
+		if err != nil || value < 0 || value > 1 {
+			problems = append(problems, fmt.Sprintf("invalid cover_sample %q, must be between 0 and 1", sample))
+		} else {
+			route.SampleRate = value
+		}
+	}
+	for _, method := range splitTagList(attributes["cover_methods"]) {
+		method = strings.ToUpper(method)
+		if _, ok := httpMethods[method]; !ok {
+			problems = append(problems, fmt.Sprintf("invalid cover_methods %q", method))
+		}
+		route.Methods = append(route.Methods, method)
+	}
+	if rollback := attributes["cover_rollback"]; rollback != "" {
+		value := rollback == "true"
+		route.Rollback = &value
+	}
+	switch mode := attributes["cover_mode"]; mode {
+	case "", CoverShadow:
+		if attributes["cover_percent"] != "" {
+			problems = append(problems, "cover_percent requires cover_mode \"canary\"")
+		}
+	case CoverCanary:
+		percent, err := strconv.ParseFloat(attributes["cover_percent"], 64)
+		if err != nil || percent < 0 || percent > 100 {
+			problems = append(problems, fmt.Sprintf("invalid cover_percent %q, must be between 0 and 100", attributes["cover_percent"]))
+			percent = 0
+		}
+		route.canary = newCoverCanary(percent)
+	default:
+		problems = append(problems, fmt.Sprintf("invalid cover_mode %q", mode))
+	}
+	return route, problems
+}
+
+// CoverUrlConfig 渐进式覆盖的配置，零值的字段使用默认值
+type CoverUrlConfig struct {
+	// 结果不一致时写入日志的目录，没有配置Sinks时每次不一致写入一个文件，统计也写入该目录
+	LogPath string
+	// 接收不一致的比较，Close时会一起关闭
+	Sinks []MismatchSink
+	// 采样率，0到1之间，默认1，可以通过cover_sample单独设置
+	SampleRate float64
+	// 允许转发的请求方法，默认只有GET，避免重复写入，可以通过cover_methods单独设置
+	Methods []string
+	// 同时转发的请求数，默认4
+	Workers int
+	// 等待转发的请求数，超过时丢弃，默认100
+	QueueSize int
+	// 转发请求的超时时间，默认10秒
+	Timeout time.Duration
+	// 转发的请求在总是回滚的事务中执行，使写接口也可以比较，可以通过cover_rollback单独设置
+	Rollback bool
+	// 自定义转发使用的客户端
+	Client *http.Client
+	// 设置后转发的请求直接交给该handler处理，一般是*gin.Engine，不再经过网络
+	// 适用于有代理、TLS卸载或者测试的场景
+	Engine http.Handler
+	// cover_target中可以使用的名字，例如 {"legacy": "http://legacy-svc"}
+	Targets map[string]string
+	// 转发到远程服务的超时时间，默认与Timeout相同
+	RemoteTimeout time.Duration
+	// 转发到远程服务时使用的客户端
+	RemoteClient *http.Client
+	// 转发到远程服务时header的处理规则
+	RemoteHeaders CoverHeaderRule
+	// canary模式下自动回滚的条件
+	Canary CoverCanaryConfig
+	// 写入Sinks前脱敏的header，请求和响应的header都会处理，为nil时使用DefaultRedactHeaders，设置为空切片时不脱敏
+	RedactHeaders []string
+}
+
+// RedactedHeaderValue 脱敏后header的值，重放时不会发送
+const RedactedHeaderValue = "[REDACTED]"
+
+// DefaultRedactHeaders 默认脱敏的header
+var DefaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "X-Auth-Token"}
+
+// CoverHeaderRule 转发到远程服务时header的处理规则
+type CoverHeaderRule struct {
+	// 删除的header，例如 Authorization、Cookie
+	Strip []string
+	// 覆盖的header，例如远程服务使用的鉴权信息
+	Set map[string]string
+	// 覆盖Host，为空时使用目标服务的Host
+	Host string
+}
+
+func (rule CoverHeaderRule) apply(request *http.Request) {
+	for _, name := range rule.Strip {
+		request.Header.Del(name)
+	}
+	for name, value := range rule.Set {
+		request.Header.Set(name, value)
+	}
+	if rule.Host != "" {
+		request.Host = rule.Host
+	}
+}
+
+// CoverUrlStats 渐进式覆盖的统计
+type CoverUrlStats struct {
+	// 转发的请求数
+	Sampled int64 `json:"sampled"`
+	// 因为采样或者请求方法没有转发的请求数
+	Skipped int64 `json:"skipped"`
+	// 队列满了被丢弃的请求数
+	Dropped int64 `json:"dropped"`
+	// 结果一致的请求数
+	Matched int64 `json:"matched"`
+	// 结果不一致的请求数
+	Mismatched int64 `json:"mismatched"`
+	// 转发失败的请求数
+	Failed int64 `json:"failed"`
+}
+
+// CoverUrl 渐进式覆盖，将旧接口的请求转发一份到新接口，比较二者的结果
+type CoverUrl struct {
+	config CoverUrlConfig
+	jobs   chan *coverJob
+	// 转发请求时携带，用于识别需要回滚的请求
+	secret string
+	stats  CoverUrlStats
+	// 每个接口的统计，key为cover_url
+	routeStats sync.Map
+	// 已经写入文件的统计，避免重复累加
+	written map[string]CoverUrlStats
+	statsMu sync.Mutex
+	// Close之后不再向jobs发送，发送时持有读锁
+	closed   bool
+	closedMu sync.RWMutex
+	wg       sync.WaitGroup
+	once     sync.Once
+}
+
+// 一次需要比较的请求，在原请求结束时复制出所有需要的数据
+type coverJob struct {
+	route    *coverRoute
+	request  *http.Request
+	body     []byte
+	original []byte
+	// 原请求的状态码、header和耗时
+	response CoverResponse
+	// canary模式下原请求交给了转发的目标处理，后台的请求发送到本接口
+	swapped bool
+	// 日志中记录的地址，始终为转发目标的地址，交换过时与request不同
+	forward *url.URL
+}
+
+const (
+	// 转发的请求携带该header时，事务总是回滚
+	coverRollbackHeader = "X-Vermouth-Cover-Rollback"
+	// 通过网络转发给自己的请求携带该header，不再转发
+	coverShadowHeader = "X-Vermouth-Cover-Shadow"
+)
+
+// 所有CoverUrl的secret，防止外部的请求伪造header
+var coverSecrets = sync.Map{}
+
+// NewCoverUrl 创建渐进式覆盖，使用Middleware注册到gin中，不再使用时调用Close
+func NewCoverUrl(config CoverUrlConfig) *CoverUrl {
+	if config.SampleRate <= 0 || config.SampleRate > 1 {
+		config.SampleRate = 1
+	}
+	if len(config.Methods) == 0 {
+		config.Methods = []string{http.MethodGet}
+	}
+	if config.Workers <= 0 {
+		config.Workers = 4
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 100
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: config.Timeout}
+	}
+	if config.RemoteTimeout <= 0 {
+		config.RemoteTimeout = config.Timeout
+	}
+	if config.RemoteClient == nil {
+		config.RemoteClient = &http.Client{Timeout: config.RemoteTimeout}
+	}
+	config.Canary.setDefaults()
+	if config.RedactHeaders == nil {
+		config.RedactHeaders = DefaultRedactHeaders
+	}
+	if len(config.Sinks) == 0 && config.LogPath != "" {
+		config.Sinks = []MismatchSink{NewDirSink(config.LogPath)}
+	}
+	cu := &CoverUrl{
+		config: config,
+		jobs:   make(chan *coverJob, config.QueueSize),
+		secret: uuid(),
+	}
+	coverSecrets.Store(cu.secret, cu)
+	for i := 0; i < config.Workers; i++ {
+		cu.wg.Add(1)
+		go cu.work()
+	}
+	return cu
+}
+
 func CoverUrlMiddleware(logPath string) gin.HandlerFunc {
-	// 如果没有该目录则创建
-	if _, err := os.Stat(logPath); os.IsNotExist(err) {
-		os.MkdirAll(logPath, 0755)
+	return NewCoverUrl(CoverUrlConfig{LogPath: logPath}).Middleware()
+}
+
+// 同时增加总的和接口的统计
+func (cu *CoverUrl) count(route *coverRoute, field func(*CoverUrlStats) *int64) {
+	atomic.AddInt64(field(&cu.stats), 1)
+	value, _ := cu.routeStats.LoadOrStore(route.key, &CoverUrlStats{})
+	atomic.AddInt64(field(value.(*CoverUrlStats)), 1)
+}
+
+func (s *CoverUrlStats) snapshot() CoverUrlStats {
+	return CoverUrlStats{
+		Sampled:    atomic.LoadInt64(&s.Sampled),
+		Skipped:    atomic.LoadInt64(&s.Skipped),
+		Dropped:    atomic.LoadInt64(&s.Dropped),
+		Matched:    atomic.LoadInt64(&s.Matched),
+		Mismatched: atomic.LoadInt64(&s.Mismatched),
+		Failed:     atomic.LoadInt64(&s.Failed),
+	}
+}
+
+// Stats 获取统计的快照
+func (cu *CoverUrl) Stats() CoverUrlStats {
+	return cu.stats.snapshot()
+}
+
+// RouteStats 获取每个接口的统计，key为接口的cover_url，有cover_target时为接口本身的路径
+func (cu *CoverUrl) RouteStats() map[string]CoverUrlStats {
+	stats := map[string]CoverUrlStats{}
+	cu.routeStats.Range(func(key, value interface{}) bool {
+		stats[key.(string)] = value.(*CoverUrlStats).snapshot()
+		return true
+	})
+	return stats
+}
+
+// 日志目录中保存统计的文件，用于计算不一致的比例
+const coverStatsFile = "cover_stats.json"
+
+// WriteStats 将每个接口的统计写入日志目录，与已有的统计累加，Close时会自动调用
+func (cu *CoverUrl) WriteStats() error {
+	if cu.config.LogPath == "" {
+		return nil
+	}
+	stats := cu.RouteStats()
+	filename := filepath.Join(cu.config.LogPath, coverStatsFile)
+	cu.statsMu.Lock()
+	defer cu.statsMu.Unlock()
+	merged := map[string]CoverUrlStats{}
+	if data, err := os.ReadFile(filename); err == nil {
+		json.Unmarshal(data, &merged)
 	}
+	for route, s := range stats {
+		written := cu.written[route]
+		m := merged[route]
+		m.Sampled += s.Sampled - written.Sampled
+		m.Skipped += s.Skipped - written.Skipped
+		m.Dropped += s.Dropped - written.Dropped
+		m.Matched += s.Matched - written.Matched
+		m.Mismatched += s.Mismatched - written.Mismatched
+		m.Failed += s.Failed - written.Failed
+		merged[route] = m
+	}
+	data, err := json.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(filename, data, 0644); err != nil {
+		return err
+	}
+	cu.written = stats
+	return nil
+}
+
+// Close 停止接收新的请求，等待队列中的请求处理完毕
+func (cu *CoverUrl) Close() {
+	cu.once.Do(func() {
+		coverSecrets.Delete(cu.secret)
+		cu.closedMu.Lock()
+		cu.closed = true
+		cu.closedMu.Unlock()
+		close(cu.jobs)
+		cu.wg.Wait()
+		for _, sink := range cu.config.Sinks {
+			if err := sink.Close(); err != nil {
+				log.Printf("vermouth: close cover sink failed: %v", err)
+			}
+		}
+		if err := cu.WriteStats(); err != nil {
+			log.Printf("vermouth: write cover stats failed: %v", err)
+		}
+	})
+}
+
+// 是否已经调用了Close
+func (cu *CoverUrl) isClosed() bool {
+	cu.closedMu.RLock()
+	defer cu.closedMu.RUnlock()
+	return cu.closed
+}
+
+// 放入队列，已经关闭或者队列已满时返回false
+func (cu *CoverUrl) enqueue(job *coverJob) bool {
+	cu.closedMu.RLock()
+	defer cu.closedMu.RUnlock()
+	if cu.closed {
+		return false
+	}
+	select {
+	case cu.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// 是否允许转发该请求方法
+func (cu *CoverUrl) allowMethod(route *coverRoute, method string) bool {
+	methods := route.Methods
+	if len(methods) == 0 {
+		methods = cu.config.Methods
+	}
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// 按采样率决定是否转发该请求
+func (cu *CoverUrl) sample(route *coverRoute) bool {
+	rate := route.SampleRate
+	if rate < 0 {
+		rate = cu.config.SampleRate
+	}
+	return rate >= 1 || mathrand.Float64() < rate
+}
+
+func (cu *CoverUrl) rollback(route *coverRoute) bool {
+	if route.Rollback != nil {
+		return *route.Rollback
+	}
+	return cu.config.Rollback
+}
+
+// Middleware 返回gin的中间件
+func (cu *CoverUrl) Middleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		url := c.Request.URL.String()
-		// 去除query
-		url = strings.Split(url, "?")[0]
-		var coveredUrl string
-		if value, ok := urlCoverCache.Load(url); ok {
-			coveredUrl = value.(string) // 类型断言
+		// 转发的请求不再转发，避免循环，关闭之后也不再比较
+		if cu.isShadow(c.Request) || cu.isClosed() {
+			c.Next()
+			return
+		}
+		path := c.Request.URL.Path
+		var route *coverRoute
+		if value, ok := urlCoverCache.Load(path); ok {
+			route = value.(*coverRoute) // 类型断言
 		} else {
 			c.Next()
 			return
 		}
+		// 不允许转发的请求方法不比较，canary模式下也不交给新接口处理，否则无法根据比较的结果自动回滚
+		if !cu.allowMethod(route, c.Request.Method) {
+			cu.count(route, func(s *CoverUrlStats) *int64 { return &s.Skipped })
+			c.Next()
+			return
+		}
+		// canary模式下，按比例交给转发的目标处理线上的请求，本接口在后台比较
+		swapped := route.canary != nil && route.canary.swap(route.Target != "")
+		// 按采样率比较
+		cover := cu.sample(route)
+		if !cover {
+			cu.count(route, func(s *CoverUrlStats) *int64 { return &s.Skipped })
+			if !swapped {
+				c.Next()
+				return
+			}
+		}
 
-		// 只有指定请求才处理
 		// 复制请求的 body
 		var bodyBytes []byte
-		if c.Request.Method == http.MethodPost {
+		if c.Request.Body != nil {
 			bodyBytes, _ = io.ReadAll(c.Request.Body)
 			c.Request.Body.Close()                                    // 关闭原始 body
-			c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes)) // 创建新的 body
+			c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes)) // 重新设置原始请求的 body
 		}
 
-		// 复制请求的 header
-		fullUrl := fmt.Sprintf("%s://%s%s?%s", func() string {
-			if c.Request.TLS != nil {
-				return "https"
+		var newRequest *http.Request
+		var forward *url.URL
+		if cover {
+			var err error
+			if newRequest, err = cu.newRequest(c.Request, route, route.Path, route.Target != "", cu.rollback(route)); err == nil {
+				forward = newRequest.URL
+				// 交换过时后台的请求发送到本接口，日志中仍记录转发目标的地址，重放时才会请求新接口
+				if swapped {
+					newRequest, err = cu.newRequest(c.Request, route, route.key, false, cu.rollback(route))
+				}
 			}
-			return "http"
-		}(), c.Request.Host, coveredUrl, c.Request.URL.RawQuery) // 添加查询参数
-		newRequest, _ := http.NewRequest(c.Request.Method, fullUrl, bytes.NewBuffer(bodyBytes))
-		for key, values := range c.Request.Header {
-			for _, value := range values {
-				newRequest.Header.Add(key, value)
+			if err != nil {
+				cu.count(route, func(s *CoverUrlStats) *int64 { return &s.Failed })
+				log.Printf("vermouth: cover request %s failed: %v", route.Path, err)
+				cover = false
+			}
+		}
+
+		start := time.Now()
+		var body []byte
+		var response CoverResponse
+		if swapped {
+			var err error
+			if body, response, err = cu.serveSwapped(c, route, bodyBytes); err != nil {
+				// 失败时交给本接口处理，不再比较
+				log.Printf("vermouth: cover canary request %s failed: %v", route.Path, err)
+				// 有cover_target时交换的目标是远程的旧接口，旧接口的失败不计入新接口
+				if route.Target == "" {
+					cu.recordCanary(route, coverFailed)
+				}
+				c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+				swapped, cover = false, false
 			}
 		}
+		if !swapped {
+			// 使用自定义的 ResponseWriter 捕获响应体
+			bw := &bodyWriter{body: bytes.NewBufferString(""), ResponseWriter: c.Writer}
+			c.Writer = bw
 
-		// 复制请求的 query 参数
-		newRequest.URL.RawQuery = c.Request.URL.RawQuery
+			c.Next() // 调用后续处理
 
-		// 继续处理原始请求
-		c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes)) // 重新设置原始请求的 body
+			body = bw.body.Bytes()
+			response = CoverResponse{Status: c.Writer.Status(), Headers: c.Writer.Header().Clone()}
+		}
+		response.Latency = time.Since(start)
+		if !cover {
+			return
+		}
 
-		// 使用自定义的 ResponseWriter 捕获响应体
-		bw := &bodyWriter{body: bytes.NewBufferString(""), ResponseWriter: c.Writer}
-		c.Writer = bw
+		job := &coverJob{route: route, request: newRequest, body: bodyBytes, original: body, response: response, swapped: swapped, forward: forward}
+		if cu.enqueue(job) {
+			cu.count(route, func(s *CoverUrlStats) *int64 { return &s.Sampled })
+		} else {
+			// 队列已满或者已经关闭，丢弃，不影响原请求
+			cu.count(route, func(s *CoverUrlStats) *int64 { return &s.Dropped })
+		}
+	}
+}
+
+// 由转发的目标处理线上的请求，并写入原请求的响应
+func (cu *CoverUrl) serveSwapped(c *gin.Context, route *coverRoute, body []byte) ([]byte, CoverResponse, error) {
+	remote := route.Target != ""
+	request, err := cu.newRequest(c.Request, route, route.Path, remote, false)
+	if err != nil {
+		return nil, CoverResponse{}, err
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), cu.timeout(remote))
+	defer cancel()
+	request = request.WithContext(ctx)
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	request.ContentLength = int64(len(body))
+	responseBody, response, err := cu.send(remote, request)
+	if err != nil {
+		return nil, CoverResponse{}, err
+	}
+	for name, values := range response.Headers {
+		c.Writer.Header()[name] = values
+	}
+	c.Writer.WriteHeader(response.Status)
+	c.Writer.Write(responseBody)
+	c.Abort()
+	return responseBody, response, nil
+}
 
-		c.Next() // 调用后续处理
+// 是否是转发的请求
+func (cu *CoverUrl) isShadow(request *http.Request) bool {
+	if request.Context().Value(coverShadowKey{}) != nil {
+		return true
+	}
+	secret := request.Header.Get(coverShadowHeader)
+	if secret == "" {
+		return false
+	}
+	_, ok := coverSecrets.Load(secret)
+	return ok
+}
 
-		go func() {
-			// 发送复制的请求
-			client := &http.Client{}
-			resp, err := client.Do(newRequest)
-			if err != nil {
-				c.AbortWithStatus(http.StatusInternalServerError) // 处理错误
-				return
+// 复制需要转发的请求，path为本服务或者远程服务上的路径，body在转发时再设置
+func (cu *CoverUrl) newRequest(original *http.Request, route *coverRoute, path string, remote bool, rollback bool) (*http.Request, error) {
+	fullUrl := path + "?" + original.URL.RawQuery // 添加查询参数
+	host := original.Host
+	if remote {
+		target := route.Target
+		if named, ok := cu.config.Targets[target]; ok {
+			target = strings.TrimRight(named, "/")
+		} else if !strings.Contains(target, "://") {
+			return nil, fmt.Errorf("unknown cover_target %q", target)
+		}
+		fullUrl = target + fullUrl
+		host = ""
+	} else if cu.config.Engine == nil {
+		fullUrl = fmt.Sprintf("%s://%s%s", func() string {
+			if original.TLS != nil {
+				return "https"
 			}
-			defer resp.Body.Close()
-			duplicateResponseBody, _ := io.ReadAll(resp.Body)
-			originalResponseBody := bw.body.Bytes() // 获取响应体
-			if !bytes.Equal(originalResponseBody, duplicateResponseBody) {
-				logData := map[string]interface{}{
-					"request": map[string]interface{}{
-						"method":  c.Request.Method,
-						"url":     c.Request.URL.String(),
-						"headers": c.Request.Header,
-						"body":    string(bodyBytes),
-						"query":   c.Request.URL.RawQuery,
-					},
-					"original_response":  string(originalResponseBody),
-					"duplicate_response": string(duplicateResponseBody),
-				}
+			return "http"
+		}(), original.Host, fullUrl)
+	}
+	request, err := http.NewRequest(original.Method, fullUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	// 复制请求的 header
+	request.Header = original.Header.Clone()
+	request.Header.Del(coverRollbackHeader)
+	request.Header.Del(coverShadowHeader)
+	if remote {
+		cu.config.RemoteHeaders.apply(request)
+		return request, nil
+	}
+	request.Host = host
+	request.RemoteAddr = original.RemoteAddr
+	request.Header.Set(coverShadowHeader, cu.secret)
+	if rollback {
+		request.Header.Set(coverRollbackHeader, cu.secret)
+	}
+	return request, nil
+}
 
-				// 记录日志
-				logJSON, err := json.Marshal(logData)
-				if err != nil {
-					c.AbortWithStatus(http.StatusInternalServerError) // 处理日志记录错误
-					return
-				}
-				// 在logfile目录按时间戳和uuid写入新的请求文件
-				uuid := uuid()
-				timestamp := time.Now().Format("2006-01-02_15-04-05")
-				filename := fmt.Sprintf("%s/%s_%s.json", logPath, timestamp, uuid)
-				err = os.WriteFile(filename, logJSON, 0644)
-				if err != nil {
-					c.AbortWithStatus(http.StatusInternalServerError) // 处理日志记录错误
-					return
-				}
+// 远程服务使用单独的超时时间
+func (cu *CoverUrl) timeout(remote bool) time.Duration {
+	if remote {
+		return cu.config.RemoteTimeout
+	}
+	return cu.config.Timeout
+}
+
+func (cu *CoverUrl) work() {
+	defer cu.wg.Done()
+	for job := range cu.jobs {
+		cu.compare(job)
+	}
+}
+
+func (cu *CoverUrl) compare(job *coverJob) {
+	// 发送复制的请求，canary模式下交换过时发送到本接口
+	remote := job.route.Target != "" && !job.swapped
+	// 后台的请求是否发送到新接口，没有cover_target时转发的目标是新接口，否则本接口是新接口
+	toNew := !job.swapped == (job.route.Target == "")
+	ctx, cancel := context.WithTimeout(context.Background(), cu.timeout(remote))
+	defer cancel()
+	request := job.request.WithContext(ctx)
+	request.Body = io.NopCloser(bytes.NewReader(job.body))
+	request.ContentLength = int64(len(job.body))
+	start := time.Now()
+	body, response, err := cu.send(remote, request)
+	if err != nil {
+		cu.count(job.route, func(s *CoverUrlStats) *int64 { return &s.Failed })
+		log.Printf("vermouth: cover request %s failed: %v", request.URL.Path, err)
+		if toNew {
+			cu.recordCanary(job.route, coverFailed)
+		}
+		return
+	}
+	response.Latency = time.Since(start)
+	// original始终是本接口的结果，duplicate始终是转发目标的结果
+	originalBody, original, duplicateBody, duplicate := job.original, job.response, body, response
+	if job.swapped {
+		originalBody, original, duplicateBody, duplicate = body, response, job.original, job.response
+	}
+	// 按json的语义比较，忽略key的顺序、数字格式和配置的字段
+	diffs := DiffJSON(originalBody, duplicateBody, job.route.Diff)
+	newStatus := duplicate.Status
+	if job.route.Target != "" {
+		newStatus = original.Status
+	}
+	switch {
+	case newStatus >= http.StatusInternalServerError:
+		cu.recordCanary(job.route, coverFailed)
+	case len(diffs) > 0:
+		cu.recordCanary(job.route, coverMismatched)
+	default:
+		cu.recordCanary(job.route, coverMatched)
+	}
+	if len(diffs) == 0 {
+		cu.count(job.route, func(s *CoverUrlStats) *int64 { return &s.Matched })
+		return
+	}
+	cu.count(job.route, func(s *CoverUrlStats) *int64 { return &s.Mismatched })
+	headers := cu.redact(job.request.Header)
+	headers.Del(coverRollbackHeader)
+	headers.Del(coverShadowHeader)
+	original.Headers, duplicate.Headers = cu.redact(original.Headers), cu.redact(duplicate.Headers)
+	event := &CoverMismatch{
+		Request: CoverRequest{
+			Method:  job.request.Method,
+			URL:     job.forward.String(),
+			Headers: headers,
+			Body:    string(job.body),
+			Query:   job.forward.RawQuery,
+		},
+		Route:             job.route.key,
+		Target:            job.route.Target,
+		Time:              time.Now(),
+		Options:           job.route.Diff,
+		OriginalResponse:  string(originalBody),
+		DuplicateResponse: string(duplicateBody),
+		Original:          original,
+		Duplicate:         duplicate,
+		Diff:              diffs,
+	}
+	for _, sink := range cu.config.Sinks {
+		if err = sink.Write(event); err != nil {
+			log.Printf("vermouth: write cover mismatch failed: %v", err)
+		}
+	}
+}
+
+// 复制header并替换敏感的值，保留名字便于排查
+func (cu *CoverUrl) redact(headers http.Header) http.Header {
+	headers = headers.Clone()
+	for _, name := range cu.config.RedactHeaders {
+		if _, ok := headers[http.CanonicalHeaderKey(name)]; ok {
+			headers.Set(name, RedactedHeaderValue)
+		}
+	}
+	return headers
+}
+
+type coverShadowKey struct{}
+
+// 发送转发的请求，返回响应体和状态码、header
+func (cu *CoverUrl) send(remote bool, request *http.Request) ([]byte, CoverResponse, error) {
+	client := cu.config.Client
+	if remote {
+		client = cu.config.RemoteClient
+	} else if cu.config.Engine != nil {
+		return cu.serve(request)
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, CoverResponse{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return body, CoverResponse{Status: resp.StatusCode, Headers: resp.Header}, err
+}
+
+// 在当前进程中执行转发的请求，与原请求的gin.Context完全无关
+// 超时后不再等待，但handler仍会执行完毕
+func (cu *CoverUrl) serve(request *http.Request) ([]byte, CoverResponse, error) {
+	ctx := request.Context()
+	request = request.WithContext(context.WithValue(ctx, coverShadowKey{}, true))
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		recorder := httptest.NewRecorder()
+		defer func() {
+			if e := recover(); e != nil {
+				log.Printf("vermouth: cover request %s panic: %v\n%s", request.URL.Path, e, debug.Stack())
+				close(done)
+				return
 			}
+			done <- recorder
 		}()
+		cu.config.Engine.ServeHTTP(recorder, request)
+	}()
+	select {
+	case recorder, ok := <-done:
+		if !ok {
+			return nil, CoverResponse{}, fmt.Errorf("cover request %s panic", request.URL.Path)
+		}
+		return recorder.Body.Bytes(), CoverResponse{Status: recorder.Code, Headers: recorder.Header()}, nil
+	case <-ctx.Done():
+		return nil, CoverResponse{}, ctx.Err()
+	}
+}
 
-		// 输出原请求结果
-		// c.Writer.Write(originalResponseBody)
-
-		// // 比较请求结果
-		// if !bytes.Equal(originalResponseBody, duplicateResponseBody) {
-		// 	// 处理不匹配的情况
-		// 	c.JSON(http.StatusConflict, gin.H{"error": "Responses do not match"})
-		// 	return
-		// }
+// 是否是需要回滚的转发请求
+func isCoverRollback(ctx *Context) bool {
+	secret := ctx.HttpContext.Request().Header.Get(coverRollbackHeader)
+	if secret == "" {
+		return false
 	}
+	_, ok := coverSecrets.Load(secret)
+	return ok
 }
 
 func uuid() string {
@@ -1,13 +1,53 @@
 package vermouth
 
-import "database/sql"
+import (
+	"database/sql"
+	"sync"
+)
 
 var db *sql.DB
 
+var (
+	namedDBs   = map[string]*sql.DB{}
+	namedDBsMu sync.RWMutex
+)
+
 func SetDB(_db *sql.DB) {
 	db = _db
 }
 
 func GetDB() *sql.DB {
 	return db
-}
\ No newline at end of file
+}
+
+// SetNamedDB 注册一个命名的数据源，name为空时等同于SetDB，db为nil时删除该数据源
+func SetNamedDB(name string, _db *sql.DB) {
+	if name == "" {
+		SetDB(_db)
+		return
+	}
+	namedDBsMu.Lock()
+	defer namedDBsMu.Unlock()
+	if _db == nil {
+		delete(namedDBs, name)
+		return
+	}
+	namedDBs[name] = _db
+}
+
+// GetNamedDB 获取命名的数据源，name为空时返回默认数据源
+func GetNamedDB(name string) *sql.DB {
+	if name == "" {
+		return GetDB()
+	}
+	namedDBsMu.RLock()
+	defer namedDBsMu.RUnlock()
+	return namedDBs[name]
+}
+
+func hasNamedDB(name string) bool {
+	namedDBsMu.RLock()
+	defer namedDBsMu.RUnlock()
+	_, ok := namedDBs[name]
+	return ok
+}
@@ -1,5 +1,14 @@
 package vermouth
 
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+)
+
 type RuntimeError struct {
 	Code    int
 	Message string
@@ -11,4 +20,79 @@ func (e *RuntimeError) Error() string {
 
 func NewRuntimeError(code int, message string) *RuntimeError {
 	return &RuntimeError{Code: code, Message: message}
-}
\ No newline at end of file
+}
+
+type errorHandler struct {
+	errType reflect.Type
+	fn      func(*Context, error)
+}
+
+var (
+	errorType     = reflect.TypeOf((*error)(nil)).Elem()
+	errorHandlers = []*errorHandler{}
+)
+
+func init() {
+	// 内置的错误处理器
+	RegisterErrorHandler((*ValidatorError)(nil), func(ctx *Context, err error) {
+		ve := err.(*ValidatorError)
+		ctx.Fail(http.StatusBadRequest, http.StatusBadRequest, ve.Error(), ve.ErrorMessages)
+	})
+	RegisterErrorHandler((*RuntimeError)(nil), func(ctx *Context, err error) {
+		re := err.(*RuntimeError)
+		// Code不是合法的http状态码时，统一按500返回
+		status := re.Code
+		if status < 100 || status > 599 {
+			status = http.StatusInternalServerError
+		}
+		ctx.Fail(status, re.Code, re.Message, nil)
+	})
+}
+
+// RegisterErrorHandler 注册错误处理器
+// errType为错误类型的零值，例如 (*RuntimeError)(nil)，如果要按接口匹配，传入接口的指针，例如 (*MyErrorInterface)(nil)
+// 匹配规则同errors.As，后注册的处理器优先匹配，因此可以覆盖内置的处理器
+func RegisterErrorHandler(errType interface{}, fn func(*Context, error)) {
+	t := reflect.TypeOf(errType)
+	if t == nil {
+		panic("errType must not be nil")
+	}
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Interface {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Interface && !t.Implements(errorType) {
+		panic(fmt.Sprintf("%v does not implement error", t))
+	}
+	errorHandlers = append(errorHandlers, &errorHandler{errType: t, fn: fn})
+}
+
+// 将控制器中抛出的异常或返回的错误转换为响应
+func handleError(ctx *Context, e interface{}) {
+	err, ok := e.(error)
+	if !ok {
+		err = fmt.Errorf("%v", e)
+	}
+	// 错误处理器接管了响应，不再自动返回
+	ctx.AutoReturn = false
+	for i := len(errorHandlers) - 1; i >= 0; i-- {
+		handler := errorHandlers[i]
+		target := reflect.New(handler.errType)
+		if errors.As(err, target.Interface()) {
+			handler.fn(ctx, target.Elem().Interface().(error))
+			return
+		}
+	}
+	// 未知错误，记录日志后返回通用的500，使用traceId关联日志
+	traceId := ctx.TraceId()
+	log.Printf("vermouth: unhandled error [%s] %s: %v\n%s", traceId, ctx.ControllerInformation.Path, e, debug.Stack())
+	ctx.Fail(http.StatusInternalServerError, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), nil)
+}
+
+// 如果最后一个返回值是非空的error，则返回该error
+func resultError(result []interface{}) error {
+	if len(result) == 0 {
+		return nil
+	}
+	err, _ := result[len(result)-1].(error)
+	return err
+}
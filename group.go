@@ -0,0 +1,137 @@
+package vermouth
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// PanicError 子协程中的panic，在Group.Wait中重新抛出
+type PanicError struct {
+	Value interface{}
+	// 子协程panic时的调用栈
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in goroutine: %v", e.Value)
+}
+
+// Unwrap panic的值是error时返回该error，错误处理器可以按原来的类型处理
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Group 一组继承所有ThreadLocal的子协程，类似errgroup
+type Group struct {
+	wg     sync.WaitGroup
+	sem    chan struct{}
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	err      error
+	panicErr *PanicError
+}
+
+// NewGroup 创建Group，子协程继承当前协程在所有ThreadLocal中的值，包括CurrentContext和当前的事务
+func NewGroup() *Group {
+	return &Group{}
+}
+
+// GroupWithContext 创建Group，任意子协程返回错误或者panic时取消返回的context
+func GroupWithContext(ctx context.Context) (*Group, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Group{cancel: cancel}, ctx
+}
+
+// Group 等同于NewGroup，子协程继承所有ThreadLocal的值
+func (tl *ThreadLocal[T]) Group() *Group {
+	return NewGroup()
+}
+
+// SetLimit 限制同时运行的子协程数，小于等于0时不限制，必须在调用Go之前设置
+func (g *Group) SetLimit(n int) {
+	if len(g.sem) != 0 {
+		panic(fmt.Errorf("vermouth: modify limit while %d goroutines in the group are still active", len(g.sem)))
+	}
+	if n <= 0 {
+		g.sem = nil
+		return
+	}
+	g.sem = make(chan struct{}, n)
+}
+
+// Go 启动子协程，达到并发限制时阻塞到有子协程结束
+func (g *Group) Go(f func() error) {
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+	g.start(f)
+}
+
+// TryGo 达到并发限制时不启动子协程，返回false
+func (g *Group) TryGo(f func() error) bool {
+	if g.sem != nil {
+		select {
+		case g.sem <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	g.start(f)
+	return true
+}
+
+func (g *Group) start(f func() error) {
+	g.wg.Add(1)
+	go wrapThreadLocals(func() {
+		defer g.done()
+		// 子协程的panic不会导致进程退出，由Wait重新抛出
+		defer func() {
+			if e := recover(); e != nil {
+				g.fail(nil, &PanicError{Value: e, Stack: debug.Stack()})
+			}
+		}()
+		if err := f(); err != nil {
+			g.fail(err, nil)
+		}
+	})()
+}
+
+func (g *Group) done() {
+	if g.sem != nil {
+		<-g.sem
+	}
+	g.wg.Done()
+}
+
+// 只记录第一个错误和第一个panic
+func (g *Group) fail(err error, panicErr *PanicError) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err != nil && g.err == nil {
+		g.err = err
+	}
+	if panicErr != nil && g.panicErr == nil {
+		g.panicErr = panicErr
+	}
+	if g.cancel != nil {
+		g.cancel()
+	}
+}
+
+// Wait 等待所有子协程结束，返回第一个错误，有子协程panic时在当前协程抛出*PanicError
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	if g.cancel != nil {
+		g.cancel()
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.panicErr != nil {
+		panic(g.panicErr)
+	}
+	return g.err
+}
@@ -0,0 +1,218 @@
+package vermouth
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSONDiff 两个json之间的一处差异，Path使用.分隔，数组下标也作为一段，根节点为空
+type JSONDiff struct {
+	Path string `json:"path"`
+	// changed、added、removed
+	Kind      string      `json:"kind"`
+	Original  interface{} `json:"original,omitempty"`
+	Duplicate interface{} `json:"duplicate,omitempty"`
+}
+
+// JSONDiffOptions 比较json时的选项
+type JSONDiffOptions struct {
+	// 忽略的路径，例如 data.createdAt，可以使用*匹配任意一段，例如 data.items.*.id
+	Ignore []string `json:"ignore,omitempty"`
+	// 小数允许的误差，整数总是精确比较
+	Tolerance float64 `json:"tolerance,omitempty"`
+	// 不关心顺序的数组路径，写*表示所有的数组
+	Unordered []string `json:"unordered,omitempty"`
+}
+
+// DiffJSON 按语义比较两个json，忽略key的顺序和数字的格式
+// 任意一方不是合法的json时按字节比较
+func DiffJSON(original, duplicate []byte, options JSONDiffOptions) []JSONDiff {
+	a, errA := decodeJSON(original)
+	b, errB := decodeJSON(duplicate)
+	if errA != nil || errB != nil {
+		if bytes.Equal(original, duplicate) {
+			return nil
+		}
+		return []JSONDiff{{Kind: "changed", Original: string(original), Duplicate: string(duplicate)}}
+	}
+	d := &jsonDiffer{options: options}
+	d.diff(nil, a, b)
+	return d.diffs
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+type jsonDiffer struct {
+	options JSONDiffOptions
+	diffs   []JSONDiff
+}
+
+func (d *jsonDiffer) add(path []string, kind string, original, duplicate interface{}) {
+	d.diffs = append(d.diffs, JSONDiff{Path: strings.Join(path, "."), Kind: kind, Original: original, Duplicate: duplicate})
+}
+
+func (d *jsonDiffer) diff(path []string, a, b interface{}) {
+	if matchJSONPaths(d.options.Ignore, path) {
+		return
+	}
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			d.add(path, "changed", a, b)
+			return
+		}
+		keys := make([]string, 0, len(av)+len(bv))
+		for k := range av {
+			keys = append(keys, k)
+		}
+		for k := range bv {
+			if _, ok := av[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			child := appendPath(path, k)
+			va, okA := av[k]
+			vb, okB := bv[k]
+			switch {
+			case !okA:
+				if !matchJSONPaths(d.options.Ignore, child) {
+					d.add(child, "added", nil, vb)
+				}
+			case !okB:
+				if !matchJSONPaths(d.options.Ignore, child) {
+					d.add(child, "removed", va, nil)
+				}
+			default:
+				d.diff(child, va, vb)
+			}
+		}
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			d.add(path, "changed", a, b)
+			return
+		}
+		if matchJSONPaths(d.options.Unordered, path) {
+			d.diffUnordered(path, av, bv)
+			return
+		}
+		for i := 0; i < len(av) || i < len(bv); i++ {
+			child := appendPath(path, strconv.Itoa(i))
+			switch {
+			case i >= len(av):
+				d.add(child, "added", nil, bv[i])
+			case i >= len(bv):
+				d.add(child, "removed", av[i], nil)
+			default:
+				d.diff(child, av[i], bv[i])
+			}
+		}
+	case json.Number:
+		bv, ok := b.(json.Number)
+		if !ok || !d.equalNumber(av, bv) {
+			d.add(path, "changed", a, b)
+		}
+	default:
+		if !reflect.DeepEqual(a, b) {
+			d.add(path, "changed", a, b)
+		}
+	}
+}
+
+// 不关心顺序时，每个元素在另一边找一个没有差异的元素配对，剩下的作为差异
+func (d *jsonDiffer) diffUnordered(path []string, a, b []interface{}) {
+	matched := make([]bool, len(b))
+	for i, va := range a {
+		child := appendPath(path, strconv.Itoa(i))
+		found := false
+		for j, vb := range b {
+			if matched[j] {
+				continue
+			}
+			sub := &jsonDiffer{options: d.options}
+			sub.diff(child, va, vb)
+			if len(sub.diffs) == 0 {
+				matched[j] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			d.add(child, "removed", va, nil)
+		}
+	}
+	for j, vb := range b {
+		if !matched[j] {
+			d.add(appendPath(path, strconv.Itoa(j)), "added", nil, vb)
+		}
+	}
+}
+
+func (d *jsonDiffer) equalNumber(a, b json.Number) bool {
+	if a == b {
+		return true
+	}
+	// 整数精确比较，超过2^53的整数（例如雪花ID）转换为float64后会相等
+	ia, okA := new(big.Int).SetString(string(a), 10)
+	ib, okB := new(big.Int).SetString(string(b), 10)
+	if okA && okB {
+		return ia.Cmp(ib) == 0
+	}
+	fa, errA := a.Float64()
+	fb, errB := b.Float64()
+	if errA != nil || errB != nil {
+		return false
+	}
+	return math.Abs(fa-fb) <= d.options.Tolerance
+}
+
+// 复制一份，避免共用底层数组
+func appendPath(path []string, name string) []string {
+	child := make([]string, len(path)+1)
+	copy(child, path)
+	child[len(path)] = name
+	return child
+}
+
+func matchJSONPaths(patterns []string, path []string) bool {
+	for _, pattern := range patterns {
+		if matchJSONPath(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// *单独出现时匹配所有路径，否则按段匹配
+func matchJSONPath(pattern string, path []string) bool {
+	if pattern == "*" {
+		return true
+	}
+	segments := strings.Split(pattern, ".")
+	if len(segments) != len(path) {
+		return false
+	}
+	for i, segment := range segments {
+		if segment != "*" && segment != path[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,418 @@
+package vermouth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 与*sql.DB和*sql.Tx共有的方法
+type sqlExecutor interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+type mapperMethod struct {
+	index    int
+	fnType   reflect.Type
+	query    string
+	names    []string
+	isQuery  bool
+	insertId bool
+	// 参数名 -> 参数位置
+	params   map[string]int
+	ctxIndex int
+	txIndex  int
+	// 数据源的名字，为空时使用默认数据源
+	db string
+}
+
+var (
+	namedParamRegexp = regexp.MustCompile(`#\{\s*([\w.]+)\s*\}`)
+	sqlResultType    = reflect.TypeOf((*sql.Result)(nil)).Elem()
+	timeType         = reflect.TypeOf(time.Time{})
+)
+
+// NewMapper 为dao中所有带有sql tag的方法字段生成实现
+// sql中使用#{name}引用参数，参数名通过params tag声明，没有声明时按出现的顺序对应
+// 查询语句的结果会按列名扫描到返回值中，其他语句可以返回影响的行数，或者使用 result:"insert_id" 返回自增id
+// 当前请求开启了事务时，会自动加入该事务，使用 db:"name" 指定命名的数据源
+//
+//	type UserDao struct {
+//		FindByName func(ctx context.Context, name string) ([]User, error) `sql:"SELECT * FROM user WHERE name = #{name}"`
+//	}
+func NewMapper(dao interface{}) error {
+	v := reflect.ValueOf(dao)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return &DefinitionError{Messages: []string{fmt.Sprintf("mapper %T must be a pointer to struct", dao)}}
+	}
+	v = v.Elem()
+	t := v.Type()
+	de := &DefinitionError{}
+	var methods []*mapperMethod
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Type.Kind() != reflect.Func || field.Tag.Get("sql") == "" {
+			continue
+		}
+		m, problems := parseMapperMethod(t.Name()+"."+field.Name, field)
+		m.index = i
+		methods = append(methods, m)
+		de.Messages = append(de.Messages, problems...)
+	}
+	if len(de.Messages) > 0 {
+		return de
+	}
+	for _, m := range methods {
+		v.Field(m.index).Set(reflect.MakeFunc(m.fnType, m.call))
+	}
+	return nil
+}
+
+func parseMapperMethod(name string, field reflect.StructField) (*mapperMethod, []string) {
+	m := &mapperMethod{
+		fnType:   field.Type,
+		params:   map[string]int{},
+		ctxIndex: -1,
+		txIndex:  -1,
+		insertId: field.Tag.Get("result") == "insert_id",
+		db:       field.Tag.Get("db"),
+	}
+	var problems []string
+	rawSql := strings.TrimSpace(field.Tag.Get("sql"))
+	for _, match := range namedParamRegexp.FindAllStringSubmatch(rawSql, -1) {
+		m.names = append(m.names, match[1])
+	}
+	m.query = namedParamRegexp.ReplaceAllString(rawSql, "?")
+	// 第一个关键字决定是否为查询，忽略开头的括号和换行、制表符等空白
+	var keyword string
+	if tokens := strings.Fields(strings.TrimLeft(rawSql, "( \t\r\n")); len(tokens) > 0 {
+		keyword = strings.ToUpper(tokens[0])
+	}
+	switch keyword {
+	case "SELECT", "WITH", "SHOW", "DESC", "DESCRIBE", "EXPLAIN":
+		m.isQuery = true
+	}
+
+	// 需要绑定的参数
+	var args []int
+	for i := 0; i < m.fnType.NumIn(); i++ {
+		switch m.fnType.In(i) {
+		case goContextType:
+			m.ctxIndex = i
+		case txType:
+			m.txIndex = i
+		default:
+			args = append(args, i)
+		}
+	}
+	if params := field.Tag.Get("params"); params != "" {
+		names := strings.Split(params, ",")
+		if len(names) != len(args) {
+			problems = append(problems, fmt.Sprintf("%s: params declares %d names but func takes %d arguments", name, len(names), len(args)))
+		}
+		for i, paramName := range names {
+			if i < len(args) {
+				m.params[strings.TrimSpace(paramName)] = args[i]
+			}
+		}
+	} else {
+		// 没有声明参数名时，基础类型的参数按sql中出现的顺序对应
+		var distinct []string
+		seen := map[string]struct{}{}
+		for _, n := range m.names {
+			root := strings.SplitN(n, ".", 2)[0]
+			if _, ok := seen[root]; !ok {
+				seen[root] = struct{}{}
+				distinct = append(distinct, root)
+			}
+		}
+		pos := 0
+		for _, i := range args {
+			if isStructOrMap(m.fnType.In(i)) {
+				continue
+			}
+			if pos < len(distinct) {
+				m.params[distinct[pos]] = i
+				pos++
+			}
+		}
+	}
+	for _, n := range m.names {
+		if !m.canResolve(n) {
+			problems = append(problems, fmt.Sprintf("%s: no argument for #{%s}", name, n))
+		}
+	}
+
+	numOut := m.fnType.NumOut()
+	if numOut == 0 || m.fnType.Out(numOut-1) != errorType {
+		problems = append(problems, fmt.Sprintf("%s: error must be the last return value", name))
+	} else if numOut > 2 {
+		problems = append(problems, fmt.Sprintf("%s: at most 2 return values are supported", name))
+	} else if numOut == 2 && !m.isQuery {
+		switch m.fnType.Out(0).Kind() {
+		case reflect.Int, reflect.Int64:
+		default:
+			if m.fnType.Out(0) != sqlResultType {
+				problems = append(problems, fmt.Sprintf("%s: exec statement can only return int, int64 or sql.Result", name))
+			}
+		}
+	} else if numOut == 2 && m.isQuery {
+		// map只能接收map[string]interface{}，其他类型的值无法设置
+		row := m.fnType.Out(0)
+		if row.Kind() == reflect.Slice && row.Elem().Kind() != reflect.Uint8 {
+			row = row.Elem()
+		}
+		row = getReadType(row)
+		if row.Kind() == reflect.Map && (row.Key() != reflect.TypeOf("") || row.Elem().Kind() != reflect.Interface || row.Elem().NumMethod() != 0) {
+			problems = append(problems, fmt.Sprintf("%s: query result map must be map[string]interface{}, got %v", name, row))
+		}
+	}
+	return m, problems
+}
+
+// 参数名是否可以找到对应的参数
+func (m *mapperMethod) canResolve(name string) bool {
+	root := strings.SplitN(name, ".", 2)[0]
+	if _, ok := m.params[root]; ok {
+		return true
+	}
+	for i := 0; i < m.fnType.NumIn(); i++ {
+		if isStructOrMap(m.fnType.In(i)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *mapperMethod) call(args []reflect.Value) []reflect.Value {
+	res, err := m.do(args)
+	outs := make([]reflect.Value, m.fnType.NumOut())
+	if len(outs) == 2 {
+		if res.IsValid() {
+			outs[0] = res
+		} else {
+			outs[0] = reflect.Zero(m.fnType.Out(0))
+		}
+	}
+	if err != nil {
+		outs[len(outs)-1] = reflect.ValueOf(&err).Elem()
+	} else {
+		outs[len(outs)-1] = reflect.Zero(errorType)
+	}
+	return outs
+}
+
+func (m *mapperMethod) do(args []reflect.Value) (reflect.Value, error) {
+	ctx := context.Background()
+	if m.ctxIndex >= 0 && !args[m.ctxIndex].IsNil() {
+		ctx = args[m.ctxIndex].Interface().(context.Context)
+	}
+	// 优先使用传入的事务，其次是当前请求的事务
+	var executor sqlExecutor
+	if m.txIndex >= 0 && !args[m.txIndex].IsNil() {
+		executor = args[m.txIndex].Interface().(*sql.Tx)
+	} else if tx := NamedTx(ctx, m.db); tx != nil {
+		executor = tx
+	} else if db := GetNamedDB(m.db); db != nil {
+		executor = db
+	} else if m.db != "" {
+		return reflect.Value{}, fmt.Errorf("vermouth: database %s not found, call SetNamedDB first", m.db)
+	} else {
+		return reflect.Value{}, fmt.Errorf("vermouth: no database, call SetDB first")
+	}
+
+	values := make([]interface{}, len(m.names))
+	for i, name := range m.names {
+		value, err := m.resolve(name, args)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		values[i] = value
+	}
+
+	if !m.isQuery {
+		result, err := executor.ExecContext(ctx, m.query, values...)
+		if err != nil || m.fnType.NumOut() == 1 {
+			return reflect.Value{}, err
+		}
+		out := m.fnType.Out(0)
+		if out == sqlResultType {
+			return reflect.ValueOf(&result).Elem(), nil
+		}
+		var n int64
+		if m.insertId {
+			n, err = result.LastInsertId()
+		} else {
+			n, err = result.RowsAffected()
+		}
+		return reflect.ValueOf(n).Convert(out), err
+	}
+
+	rows, err := executor.QueryContext(ctx, m.query, values...)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	defer rows.Close()
+	if m.fnType.NumOut() == 1 {
+		return reflect.Value{}, rows.Err()
+	}
+	return scanRows(rows, m.fnType.Out(0))
+}
+
+// 根据参数名取值，支持user.Name的写法，也可以直接使用结构体或map参数中的字段
+func (m *mapperMethod) resolve(name string, args []reflect.Value) (interface{}, error) {
+	parts := strings.Split(name, ".")
+	if i, ok := m.params[parts[0]]; ok {
+		return lookupValue(args[i], parts[1:], name)
+	}
+	for i, arg := range args {
+		if i != m.ctxIndex && i != m.txIndex && isStructOrMap(arg.Type()) {
+			if value, err := lookupValue(arg, parts, name); err == nil {
+				return value, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("vermouth: no argument for #{%s}", name)
+}
+
+func lookupValue(v reflect.Value, path []string, name string) (interface{}, error) {
+	for _, part := range path {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return nil, nil
+			}
+			v = v.Elem()
+		}
+		switch v.Kind() {
+		case reflect.Struct:
+			field, ok := columnField(GetTypeInfo(v.Type()), part)
+			if !ok {
+				return nil, fmt.Errorf("vermouth: field %s not found for #{%s}", part, name)
+			}
+			value, err := fieldValue(v, field, false)
+			if err != nil {
+				// 嵌入的结构体指针为nil
+				return nil, nil
+			}
+			v = value
+		case reflect.Map:
+			v = v.MapIndex(reflect.ValueOf(part))
+			if !v.IsValid() {
+				return nil, fmt.Errorf("vermouth: key %s not found for #{%s}", part, name)
+			}
+		default:
+			return nil, fmt.Errorf("vermouth: can not get %s from %v for #{%s}", part, v.Type(), name)
+		}
+	}
+	return v.Interface(), nil
+}
+
+// 根据列名查找字段，依次匹配db tag、json tag、字段名，忽略大小写和下划线
+func columnField(info *TypeInfo, column string) (*FieldInfo, bool) {
+	for _, tagName := range []string{"db", "json"} {
+		if field, ok := info.FieldByTag(tagName, column); ok {
+			return field, true
+		}
+	}
+	normalized := strings.ToLower(strings.Replace(column, "_", "", -1))
+	for _, field := range info.fieldList {
+		if strings.ToLower(field.Name) == normalized {
+			return field, true
+		}
+	}
+	return nil, false
+}
+
+// 将查询结果扫描为指定的类型
+// 支持切片、结构体、结构体指针、map[string]interface{}以及基础类型
+func scanRows(rows *sql.Rows, t reflect.Type) (reflect.Value, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	isSlice := t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8
+	elemType := t
+	if isSlice {
+		elemType = t.Elem()
+	}
+	result := reflect.Zero(t)
+	if isSlice {
+		result = reflect.MakeSlice(t, 0, 0)
+	}
+	found := false
+	for rows.Next() {
+		elem, err := scanRow(rows, columns, elemType)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		found = true
+		if !isSlice {
+			result = elem
+			break
+		}
+		result = reflect.Append(result, elem)
+	}
+	if err := rows.Err(); err != nil {
+		return reflect.Value{}, err
+	}
+	// 查询单条时，指针类型返回nil，其他类型返回sql.ErrNoRows
+	if !found && !isSlice && t.Kind() != reflect.Ptr {
+		return reflect.Value{}, sql.ErrNoRows
+	}
+	return result, nil
+}
+
+func scanRow(rows *sql.Rows, columns []string, t reflect.Type) (reflect.Value, error) {
+	dests := make([]interface{}, len(columns))
+	elem := reflect.New(getReadType(t))
+	switch {
+	case elem.Elem().Kind() == reflect.Struct && elem.Elem().Type() != timeType:
+		info := GetTypeInfo(elem.Type())
+		obj := elem.Interface()
+		for i, column := range columns {
+			if field, ok := columnField(info, column); ok {
+				dests[i] = reflect.NewAt(field.Type, field.writePointer(obj)).Interface()
+			} else {
+				dests[i] = new(interface{})
+			}
+		}
+	case elem.Elem().Kind() == reflect.Map:
+		for i := range dests {
+			dests[i] = new(interface{})
+		}
+	default:
+		// 基础类型只取第一列
+		dests[0] = elem.Interface()
+		for i := 1; i < len(dests); i++ {
+			dests[i] = new(interface{})
+		}
+	}
+	if err := rows.Scan(dests...); err != nil {
+		return reflect.Value{}, err
+	}
+	if elem.Elem().Kind() == reflect.Map {
+		m := reflect.MakeMap(elem.Elem().Type())
+		for i, column := range columns {
+			value := *(dests[i].(*interface{}))
+			// 驱动返回的[]byte转换为字符串
+			if b, ok := value.([]byte); ok {
+				value = string(b)
+			}
+			if value == nil {
+				m.SetMapIndex(reflect.ValueOf(column), reflect.Zero(m.Type().Elem()))
+			} else {
+				m.SetMapIndex(reflect.ValueOf(column), reflect.ValueOf(value))
+			}
+		}
+		elem.Elem().Set(m)
+	}
+	if t.Kind() == reflect.Ptr {
+		return elem, nil
+	}
+	return elem.Elem(), nil
+}
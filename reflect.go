@@ -2,8 +2,11 @@ package vermouth
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
+	"strings"
 	"sync"
+	"time"
 	"unsafe"
 )
 
@@ -13,14 +16,48 @@ type TypeCache struct {
 }
 
 type TypeInfo struct {
+	// 包括嵌入结构体中提升的字段
 	Fields  map[string]*FieldInfo
 	Methods map[string]*MethodInfo
+	// 按声明顺序排列的字段，嵌入的结构体之后紧跟其中提升的字段
+	fieldList []*FieldInfo
+	// 标签名 -> 标签中的名称 -> 字段
+	tags map[string]map[string]*FieldInfo
 }
 
 type FieldInfo struct {
 	*reflect.StructField
 	realType reflect.Kind
-	Offset   uintptr
+	// 字段相对于结构体的偏移，提升的字段为相对于外层结构体的偏移
+	// 经过嵌入的结构体指针时为相对于最后一个嵌入结构体的偏移，不能直接与外层结构体的地址相加，需要使用GetPointer
+	Offset uintptr
+	// 解析后的标签，key为标签名
+	Tags map[string]*FieldTag
+	// 提升的字段经过的嵌入结构体指针
+	embeds []embedStep
+	// 字段类型在interface{}中的类型指针
+	typ unsafe.Pointer
+	// 是否可以直接操作内存
+	fast bool
+}
+
+// FieldTag 解析后的标签，例如`json:"user_name,omitempty"`
+type FieldTag struct {
+	// 标签的内容，user_name,omitempty
+	Value string
+	// 第一个逗号之前的名称，user_name
+	Name string
+	// 其余的选项，[omitempty]
+	Options []string
+}
+
+// 嵌入的结构体指针
+type embedStep struct {
+	name string
+	// 指针相对于上一层结构体的偏移
+	offset uintptr
+	// 指针指向的结构体类型，为nil时用于创建
+	typ reflect.Type
 }
 
 type MethodInfo struct {
@@ -60,27 +97,82 @@ func GetTypeInfo(t reflect.Type) *TypeInfo {
 		return info
 	}
 
+	fields := getFields(t)
 	info = &TypeInfo{
-		Fields:  getFields(t),
-		Methods: getMethods(t),
+		Fields:    make(map[string]*FieldInfo, len(fields)),
+		Methods:   getMethods(t),
+		fieldList: fields,
+		tags:      make(map[string]map[string]*FieldInfo),
+	}
+	for _, field := range fields {
+		info.Fields[field.Name] = field
+		for tagName, tag := range field.Tags {
+			if tag.Name == "" || tag.Name == "-" {
+				continue
+			}
+			names, ok := info.tags[tagName]
+			if !ok {
+				names = make(map[string]*FieldInfo)
+				info.tags[tagName] = names
+			}
+			// 标签重复时，嵌入层级浅的字段优先
+			if current, ok := names[tag.Name]; !ok || len(field.Index) < len(current.Index) {
+				names[tag.Name] = field
+			}
+		}
 	}
 	cache.types[t] = info
 	return info
 }
 
-func getFields(t reflect.Type) map[string]*FieldInfo {
-	fields := make(map[string]*FieldInfo)
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		fields[field.Name] = &FieldInfo{
-			StructField: &field,
+// FieldByTag 根据标签中的名称查找字段，例如FieldByTag("json", "user_name")
+func (info *TypeInfo) FieldByTag(tagName, name string) (*FieldInfo, bool) {
+	field, ok := info.tags[tagName][name]
+	return field, ok
+}
+
+// 获取所有可以访问的字段，包括嵌入结构体（以及结构体指针）中提升的字段
+func getFields(t reflect.Type) []*FieldInfo {
+	visible := reflect.VisibleFields(t)
+	fields := make([]*FieldInfo, 0, len(visible))
+	for i := range visible {
+		field := &visible[i]
+		info := &FieldInfo{
+			StructField: field,
 			realType:    getReadType(field.Type).Kind(),
-			Offset:      field.Offset,
+			Tags:        parseTags(field.Tag),
+			typ:         typePointer(field.Type),
+			fast:        isFastType(field.Type),
+		}
+		// 计算提升的字段的偏移
+		current := t
+		for _, index := range field.Index[:len(field.Index)-1] {
+			embedded := current.Field(index)
+			info.Offset += embedded.Offset
+			current = embedded.Type
+			if current.Kind() == reflect.Ptr {
+				current = current.Elem()
+				info.embeds = append(info.embeds, embedStep{name: embedded.Name, offset: info.Offset, typ: current})
+				info.Offset = 0
+			}
 		}
+		info.Offset += field.Offset
+		fields = append(fields, info)
 	}
 	return fields
 }
 
+// 将parseTag解析出的标签拆分为名称和选项
+func parseTags(tag reflect.StructTag) map[string]*FieldTag {
+	values := parseTag(tag)
+	tags := make(map[string]*FieldTag, len(values))
+	for name, value := range values {
+		parts := strings.Split(value, ",")
+		tags[name] = &FieldTag{Value: value, Name: parts[0], Options: parts[1:]}
+	}
+	return tags
+}
+
 func getMethods(t reflect.Type) map[string]*MethodInfo {
 	methods := make(map[string]*MethodInfo)
 	for i := 0; i < t.NumMethod(); i++ {
@@ -93,91 +185,337 @@ func getMethods(t reflect.Type) map[string]*MethodInfo {
 	return methods
 }
 
+// GetPointer 获取字段的地址，嵌入的结构体指针为nil时返回nil，不会修改obj
 func (t *FieldInfo) GetPointer(obj interface{}) unsafe.Pointer {
-	return unsafe.Pointer(uintptr(reflect.ValueOf(obj).Pointer()) + t.Offset)
+	ptr, _ := t.address(reflect.ValueOf(obj).UnsafePointer(), false)
+	return ptr
+}
+
+// 获取用于写入的字段地址，嵌入的结构体指针为nil时自动创建
+func (t *FieldInfo) writePointer(obj interface{}) unsafe.Pointer {
+	ptr, _ := t.address(reflect.ValueOf(obj).UnsafePointer(), true)
+	return ptr
+}
+
+// 检查obj是否为结构体指针，返回字段的地址
+func (t *FieldInfo) pointer(obj interface{}, alloc bool) (unsafe.Pointer, error) {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.Type().Elem().Kind() != reflect.Struct || v.IsNil() {
+		return nil, errors.New("obj必须是结构体指针")
+	}
+	return t.address(v.UnsafePointer(), alloc)
 }
 
+// 根据结构体的地址计算字段的地址，alloc为true时创建为nil的嵌入结构体指针
+func (t *FieldInfo) address(base unsafe.Pointer, alloc bool) (unsafe.Pointer, error) {
+	for _, embed := range t.embeds {
+		p := (*unsafe.Pointer)(unsafe.Add(base, embed.offset))
+		if *p == nil {
+			if !alloc {
+				return nil, fmt.Errorf("嵌入的字段%s为nil", embed.name)
+			}
+			*p = reflect.New(embed.typ).UnsafePointer()
+		}
+		base = *p
+	}
+	return unsafe.Add(base, t.Offset), nil
+}
+
+// 获取v中的字段，v不能寻址时使用反射
+func fieldValue(v reflect.Value, field *FieldInfo, alloc bool) (reflect.Value, error) {
+	if len(field.Index) == 1 {
+		return v.Field(field.Index[0]), nil
+	}
+	if v.CanAddr() {
+		ptr, err := field.address(v.Addr().UnsafePointer(), alloc)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.NewAt(field.Type, ptr).Elem(), nil
+	}
+	value, err := v.FieldByIndexErr(field.Index)
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("字段%s所在的嵌入结构体为nil", field.Name)
+	}
+	return value, nil
+}
+
+// Set 设置字段的值
+// 值的类型与字段类型相同时直接操作内存，值为字段类型的指针时取指针指向的值，
+// 其他可以赋值或者同种类型之间可以转换的值使用反射设置，否则返回错误
 func (t *FieldInfo) Set(obj interface{}, value interface{}) error {
-	// v := reflect.ValueOf(obj)
-	// 使用 unsafe 包直接操作内存
-	// fieldPtr := t.unsafe.Pointer(uintptr(reflect.ValueOf(obj).Pointer()) + t.Offset)
-	// val := reflect.ValueOf(value)
-
-	SetFieldByPtr(t.GetPointer(obj), value)
-	// 类型检查
-	// if t.Type != val.Type() {
-	// 	return errors.New("提供的值类型与字段类型不匹配")
-	// }
-
-	// 根据字段类型进行赋值
-	// switch value.(type) {
-	// case int:
-	// 	*(*int)(fieldPtr) = value.(int) //int(val.Int())
-	// case string:
-	// 	*(*string)(fieldPtr) = value.(string)
-	// case bool:
-	// 	*(*bool)(fieldPtr) = value.(bool)
-	// // 可以根据需要添加更多类型
-	// default:
-	// 	// 对于其他类型，使用反射设置值
-	// 	reflect.NewAt(t.Type, fieldPtr).Elem().Set(reflect.ValueOf(value))
-	// }
+	ptr, err := t.pointer(obj, true)
+	if err != nil {
+		return err
+	}
+	if t.fast && (*emptyInterface)(unsafe.Pointer(&value)).typ == t.typ {
+		storeValue(t.Type.Kind(), ptr, (*emptyInterface)(unsafe.Pointer(&value)).word)
+		return nil
+	}
+	return setValue(t.Name, reflect.NewAt(t.Type, ptr).Elem(), value)
+}
 
+// 使用反射设置值，name用于错误信息
+func setValue(name string, field reflect.Value, value interface{}) error {
+	if value == nil {
+		switch field.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Chan, reflect.Func:
+			field.Set(reflect.Zero(field.Type()))
+			return nil
+		}
+		return fmt.Errorf("字段%s类型为%v，不能设置为nil", name, field.Type())
+	}
+	val := reflect.ValueOf(value)
+	// *int设置到int字段
+	if val.Kind() == reflect.Ptr && val.Type().Elem() == field.Type() {
+		if val.IsNil() {
+			field.Set(reflect.Zero(field.Type()))
+		} else {
+			field.Set(val.Elem())
+		}
+		return nil
+	}
+	switch {
+	case val.Type().AssignableTo(field.Type()):
+		field.Set(val)
+	case val.Kind() == field.Kind() && val.Type().ConvertibleTo(field.Type()):
+		// 底层类型相同的自定义类型，例如type Status int
+		field.Set(val.Convert(field.Type()))
+	default:
+		return fmt.Errorf("字段%s类型为%v，不能设置为%v", name, field.Type(), val.Type())
+	}
 	return nil
 }
 
+// Get 获取字段的值，基础类型、[]byte、time.Time、指针、切片和map直接读取内存
 func (t *FieldInfo) Get(obj interface{}) (interface{}, error) {
-	v := reflect.ValueOf(obj)
-	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
-		return nil, errors.New("obj必须是结构体指针")
+	ptr, err := t.pointer(obj, false)
+	if err != nil {
+		return nil, err
 	}
-	v = v.Elem()
+	if !t.fast {
+		// 对于其他类型，使用反射获取值
+		return reflect.NewAt(t.Type, ptr).Elem().Interface(), nil
+	}
+	var value interface{}
+	e := (*emptyInterface)(unsafe.Pointer(&value))
+	e.typ = t.typ
+	switch t.Type.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		// 指针类型的值直接保存在interface中
+		e.word = *(*unsafe.Pointer)(ptr)
+	default:
+		e.word = loadValue(t.Type.Kind(), ptr)
+	}
+	return value, nil
+}
+
+// interface{}的内存布局
+type emptyInterface struct {
+	typ  unsafe.Pointer
+	word unsafe.Pointer
+}
 
-	// t := v.Type()
-	// info := GetTypeInfo(t)
-	// fieldInfo, exists := info.Fields[name]
-	// if !exists {
-	// 	return nil, errors.New("字段不存在: " + name)
-	// }
+// 获取类型在interface{}中使用的类型指针
+func typePointer(t reflect.Type) unsafe.Pointer {
+	value := reflect.Zero(t).Interface()
+	return (*emptyInterface)(unsafe.Pointer(&value)).typ
+}
 
-	// 使用 unsafe 包直接操作内存
-	fieldPtr := unsafe.Pointer(uintptr(unsafe.Pointer(v.UnsafeAddr())) + t.Offset)
+// 是否可以直接操作内存
+func isFastType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128,
+		reflect.Ptr, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return true
+	case reflect.Struct:
+		return t == timeType
+	}
+	return false
+}
 
-	// 根据字段类型获取值
-	switch t.Type.Kind() {
-	case reflect.Int:
-		return *(*int)(fieldPtr), nil
+// 将src指向的值复制到dst，src为interface{}中保存的数据
+func storeValue(kind reflect.Kind, dst unsafe.Pointer, src unsafe.Pointer) {
+	switch kind {
+	case reflect.Bool:
+		*(*bool)(dst) = *(*bool)(src)
 	case reflect.String:
-		return *(*string)(fieldPtr), nil
+		*(*string)(dst) = *(*string)(src)
+	case reflect.Int:
+		*(*int)(dst) = *(*int)(src)
+	case reflect.Int8:
+		*(*int8)(dst) = *(*int8)(src)
+	case reflect.Int16:
+		*(*int16)(dst) = *(*int16)(src)
+	case reflect.Int32:
+		*(*int32)(dst) = *(*int32)(src)
+	case reflect.Int64:
+		*(*int64)(dst) = *(*int64)(src)
+	case reflect.Uint:
+		*(*uint)(dst) = *(*uint)(src)
+	case reflect.Uint8:
+		*(*uint8)(dst) = *(*uint8)(src)
+	case reflect.Uint16:
+		*(*uint16)(dst) = *(*uint16)(src)
+	case reflect.Uint32:
+		*(*uint32)(dst) = *(*uint32)(src)
+	case reflect.Uint64:
+		*(*uint64)(dst) = *(*uint64)(src)
+	case reflect.Uintptr:
+		*(*uintptr)(dst) = *(*uintptr)(src)
+	case reflect.Float32:
+		*(*float32)(dst) = *(*float32)(src)
+	case reflect.Float64:
+		*(*float64)(dst) = *(*float64)(src)
+	case reflect.Complex64:
+		*(*complex64)(dst) = *(*complex64)(src)
+	case reflect.Complex128:
+		*(*complex128)(dst) = *(*complex128)(src)
+	case reflect.Slice:
+		// 切片头的布局与元素类型无关
+		*(*[]byte)(dst) = *(*[]byte)(src)
+	case reflect.Struct:
+		*(*time.Time)(dst) = *(*time.Time)(src)
+	case reflect.Ptr, reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		*(*unsafe.Pointer)(dst) = src
+	}
+}
+
+// 复制src指向的值，返回的指针用于构造interface{}
+func loadValue(kind reflect.Kind, src unsafe.Pointer) unsafe.Pointer {
+	switch kind {
 	case reflect.Bool:
-		return *(*bool)(fieldPtr), nil
-	// 可以根据需要添加更多类型
+		return copyValue[bool](src)
+	case reflect.String:
+		return copyValue[string](src)
+	case reflect.Int:
+		return copyValue[int](src)
+	case reflect.Int8:
+		return copyValue[int8](src)
+	case reflect.Int16:
+		return copyValue[int16](src)
+	case reflect.Int32:
+		return copyValue[int32](src)
+	case reflect.Int64:
+		return copyValue[int64](src)
+	case reflect.Uint:
+		return copyValue[uint](src)
+	case reflect.Uint8:
+		return copyValue[uint8](src)
+	case reflect.Uint16:
+		return copyValue[uint16](src)
+	case reflect.Uint32:
+		return copyValue[uint32](src)
+	case reflect.Uint64:
+		return copyValue[uint64](src)
+	case reflect.Uintptr:
+		return copyValue[uintptr](src)
+	case reflect.Float32:
+		return copyValue[float32](src)
+	case reflect.Float64:
+		return copyValue[float64](src)
+	case reflect.Complex64:
+		return copyValue[complex64](src)
+	case reflect.Complex128:
+		return copyValue[complex128](src)
+	case reflect.Slice:
+		return copyValue[[]byte](src)
 	default:
-		// 对于其他类型，使用反射获取值
-		return reflect.NewAt(t.Type, fieldPtr).Elem().Interface(), nil
+		return copyValue[time.Time](src)
 	}
 }
 
-func SetFieldByPtr(ptr unsafe.Pointer, value interface{}) {
-	switch value.(type) {
-	case int:
-		*(*int)(ptr) = value.(int) //int(val.Int())
-	case *int:
-		*(*int)(ptr) = *value.(*int)
-	case string:
-		*(*string)(ptr) = value.(string)
-	case *string:
-		*(*string)(ptr) = *value.(*string)
+func copyValue[T any](src unsafe.Pointer) unsafe.Pointer {
+	value := new(T)
+	*value = *(*T)(src)
+	return unsafe.Pointer(value)
+}
+
+// SetFieldByPtr 按值的类型直接写入ptr指向的内存，调用方需保证ptr指向的类型与值的类型一致
+// 基础类型和time.Time的指针写入指向的值，指针为nil时写入零值，同FieldInfo.Set
+// 其他类型（指针、切片、map、结构体等）使用反射写入，值为nil时返回错误
+func SetFieldByPtr(ptr unsafe.Pointer, value interface{}) error {
+	switch v := value.(type) {
 	case bool:
-		*(*bool)(ptr) = value.(bool)
+		*(*bool)(ptr) = v
 	case *bool:
-		*(*bool)(ptr) = *value.(*bool)
+		*(*bool)(ptr) = deref(v)
+	case string:
+		*(*string)(ptr) = v
+	case *string:
+		*(*string)(ptr) = deref(v)
+	case int:
+		*(*int)(ptr) = v
+	case *int:
+		*(*int)(ptr) = deref(v)
+	case int8:
+		*(*int8)(ptr) = v
+	case *int8:
+		*(*int8)(ptr) = deref(v)
+	case int16:
+		*(*int16)(ptr) = v
+	case *int16:
+		*(*int16)(ptr) = deref(v)
+	case int32:
+		*(*int32)(ptr) = v
+	case *int32:
+		*(*int32)(ptr) = deref(v)
+	case int64:
+		*(*int64)(ptr) = v
+	case *int64:
+		*(*int64)(ptr) = deref(v)
+	case uint:
+		*(*uint)(ptr) = v
+	case *uint:
+		*(*uint)(ptr) = deref(v)
+	case uint8:
+		*(*uint8)(ptr) = v
+	case *uint8:
+		*(*uint8)(ptr) = deref(v)
+	case uint16:
+		*(*uint16)(ptr) = v
+	case *uint16:
+		*(*uint16)(ptr) = deref(v)
+	case uint32:
+		*(*uint32)(ptr) = v
+	case *uint32:
+		*(*uint32)(ptr) = deref(v)
+	case uint64:
+		*(*uint64)(ptr) = v
+	case *uint64:
+		*(*uint64)(ptr) = deref(v)
+	case float32:
+		*(*float32)(ptr) = v
+	case *float32:
+		*(*float32)(ptr) = deref(v)
+	case float64:
+		*(*float64)(ptr) = v
+	case *float64:
+		*(*float64)(ptr) = deref(v)
+	case []byte:
+		*(*[]byte)(ptr) = v
+	case time.Time:
+		*(*time.Time)(ptr) = v
+	case *time.Time:
+		*(*time.Time)(ptr) = deref(v)
+	case nil:
+		return errors.New("不能确定nil的类型")
+	default:
+		reflect.NewAt(reflect.TypeOf(value), ptr).Elem().Set(reflect.ValueOf(value))
+	}
+	return nil
+}
+
+// 指针为nil时返回零值
+func deref[T any](v *T) T {
+	if v == nil {
+		var zero T
+		return zero
 	}
-	// // 可以根据需要添加更多类型
-	// default:
-	// 	// 对于其他类型，使用反射设置值
-	// 	reflect.NewAt(t.Type, fieldPtr).Elem().Set(reflect.ValueOf(value))
-	// }
+	return *v
 }
 
 // 优化 SetField 函数，使用缓存和指针操作
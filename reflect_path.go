@@ -0,0 +1,400 @@
+package vermouth
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SkipPath 在Walk的回调中返回，不再访问当前值内部的字段和元素
+var SkipPath = errors.New("skip this path")
+
+// 路径中的一段，bracket为true时是[]中的下标或者map的键
+type pathSegment struct {
+	name    string
+	bracket bool
+}
+
+// 最多缓存的路径数，路径中的下标和键可能来自请求，超过时清空，避免无限增长
+const maxPathCache = 1024
+
+// 解析后的路径
+var pathCache = struct {
+	mu    sync.RWMutex
+	paths map[string][]pathSegment
+}{paths: make(map[string][]pathSegment)}
+
+// 解析Order.Items[2].Sku形式的路径，[]中的键可以使用引号，例如Meta["a.b"]
+// 双引号中可以使用Go的转义，例如Meta["a\"b"]
+func parsePath(path string) ([]pathSegment, error) {
+	pathCache.mu.RLock()
+	cached, ok := pathCache.paths[path]
+	pathCache.mu.RUnlock()
+	if ok {
+		return cached, nil
+	}
+	var segments []pathSegment
+	rest := path
+	for rest != "" {
+		if rest[0] == '[' {
+			if len(rest) > 1 && (rest[1] == '"' || rest[1] == '\'') {
+				key, next, err := parseQuotedKey(path, rest[1:])
+				if err != nil {
+					return nil, err
+				}
+				segments = append(segments, pathSegment{name: key, bracket: true})
+				rest = next
+				continue
+			}
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("路径%s缺少]", path)
+			}
+			key := rest[1:end]
+			if key == "" {
+				return nil, fmt.Errorf("路径%s中[]的内容为空", path)
+			}
+			segments = append(segments, pathSegment{name: key, bracket: true})
+			rest = rest[end+1:]
+			continue
+		}
+		if len(segments) > 0 {
+			if rest[0] != '.' {
+				return nil, fmt.Errorf("路径%s中]之后必须是.或者[", path)
+			}
+			rest = rest[1:]
+		}
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("路径%s中存在空的字段名", path)
+		}
+		segments = append(segments, pathSegment{name: rest[:end]})
+		rest = rest[end:]
+	}
+	if len(segments) == 0 {
+		return nil, errors.New("路径为空")
+	}
+	pathCache.mu.Lock()
+	if len(pathCache.paths) >= maxPathCache {
+		pathCache.paths = make(map[string][]pathSegment)
+	}
+	pathCache.paths[path] = segments
+	pathCache.mu.Unlock()
+	return segments, nil
+}
+
+// 解析[]中带引号的键，rest从引号开始，返回键和]之后的内容
+func parseQuotedKey(path, rest string) (string, string, error) {
+	var key, quoted string
+	if rest[0] == '"' {
+		prefix, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return "", "", fmt.Errorf("路径%s中的引号不完整", path)
+		}
+		key, _ = strconv.Unquote(prefix)
+		quoted = prefix
+	} else {
+		end := strings.IndexByte(rest[1:], '\'')
+		if end < 0 {
+			return "", "", fmt.Errorf("路径%s中的引号不完整", path)
+		}
+		key, quoted = rest[1:end+1], rest[:end+2]
+	}
+	rest = rest[len(quoted):]
+	if rest == "" || rest[0] != ']' {
+		return "", "", fmt.Errorf("路径%s缺少]", path)
+	}
+	return key, rest[1:], nil
+}
+
+// 拼接路径，用于错误信息和Walk
+func joinPath(path string, segment pathSegment) string {
+	if segment.bracket {
+		// 键中包含特殊字符时加上引号，保证GetPath可以解析
+		if segment.name == "" || strings.ContainsAny(segment.name, `[].'"`) {
+			return path + "[" + strconv.Quote(segment.name) + "]"
+		}
+		return path + "[" + segment.name + "]"
+	}
+	if path == "" {
+		return segment.name
+	}
+	return path + "." + segment.name
+}
+
+// 路径中的当前位置，用于错误信息
+func pathName(path string) string {
+	if path == "" {
+		return "根对象"
+	}
+	return path
+}
+
+// GetPath 按路径获取值，例如GetPath(order, "Items[2].Sku")
+// 支持结构体字段、指针、切片和数组的下标、以字符串为键的map
+func GetPath(obj interface{}, path string) (interface{}, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	v := reflect.ValueOf(obj)
+	walked := ""
+	for _, segment := range segments {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return nil, fmt.Errorf("路径%s: %s为nil", path, pathName(walked))
+			}
+			v = v.Elem()
+		}
+		if v, err = pathChild(v, segment, walked, false); err != nil {
+			return nil, fmt.Errorf("路径%s: %v", path, err)
+		}
+		walked = joinPath(walked, segment)
+	}
+	return v.Interface(), nil
+}
+
+// SetPath 按路径设置值，obj必须是指针
+// 路径中为nil的指针和map会自动创建，值的类型检查同FieldInfo.Set
+func SetPath(obj interface{}, path string, value interface{}) error {
+	segments, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return errors.New("obj必须是非nil的指针")
+	}
+	if err := setPath(v.Elem(), segments, "", value); err != nil {
+		return fmt.Errorf("路径%s: %v", path, err)
+	}
+	return nil
+}
+
+func setPath(v reflect.Value, segments []pathSegment, walked string, value interface{}) error {
+	if len(segments) == 0 {
+		return setValue(walked, v, value)
+	}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	segment := segments[0]
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return fmt.Errorf("%s为nil", pathName(walked))
+		}
+		// interface中的值不能直接修改，修改副本之后再设置回去
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		if err := setPath(elem, segments, walked, value); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	case reflect.Map:
+		key, err := pathMapKey(v, segment, walked)
+		if err != nil {
+			return err
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		// map中的值不能直接修改，修改副本之后再设置回去
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if current := v.MapIndex(key); current.IsValid() {
+			elem.Set(current)
+		}
+		if err := setPath(elem, segments[1:], joinPath(walked, segment), value); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, elem)
+		return nil
+	}
+	child, err := pathChild(v, segment, walked, true)
+	if err != nil {
+		return err
+	}
+	if !child.CanSet() {
+		return fmt.Errorf("%s不能修改", joinPath(walked, segment))
+	}
+	return setPath(child, segments[1:], joinPath(walked, segment), value)
+}
+
+// 获取结构体的字段、切片和数组的元素以及map的值，alloc为true时创建为nil的嵌入结构体指针
+func pathChild(v reflect.Value, segment pathSegment, walked string, alloc bool) (reflect.Value, error) {
+	switch v.Kind() {
+	case reflect.Struct:
+		if segment.bracket {
+			return reflect.Value{}, fmt.Errorf("%s是结构体%v，不能使用[%s]", pathName(walked), v.Type(), segment.name)
+		}
+		field, ok := GetTypeInfo(v.Type()).Fields[segment.name]
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("%s的类型%v中不存在字段%s", pathName(walked), v.Type(), segment.name)
+		}
+		if field.PkgPath != "" {
+			return reflect.Value{}, fmt.Errorf("%s的字段%s未导出", pathName(walked), segment.name)
+		}
+		child, err := fieldValue(v, field, alloc)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%s: %v", pathName(walked), err)
+		}
+		return child, nil
+	case reflect.Slice, reflect.Array:
+		if !segment.bracket {
+			return reflect.Value{}, fmt.Errorf("%s是%v，需要使用下标访问，不能使用.%s", pathName(walked), v.Type(), segment.name)
+		}
+		index, err := strconv.Atoi(segment.name)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%s的下标%s不是整数", pathName(walked), segment.name)
+		}
+		if index < 0 || index >= v.Len() {
+			return reflect.Value{}, fmt.Errorf("%s的下标%d超出范围，长度为%d", pathName(walked), index, v.Len())
+		}
+		return v.Index(index), nil
+	case reflect.Map:
+		key, err := pathMapKey(v, segment, walked)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		elem := v.MapIndex(key)
+		if !elem.IsValid() {
+			return reflect.Value{}, fmt.Errorf("%s中不存在键%s", pathName(walked), segment.name)
+		}
+		return elem, nil
+	}
+	return reflect.Value{}, fmt.Errorf("%s的类型为%v，不能访问%s", pathName(walked), v.Type(), joinPath("", segment))
+}
+
+// map的键必须是字符串类型
+func pathMapKey(v reflect.Value, segment pathSegment, walked string) (reflect.Value, error) {
+	if v.Type().Key().Kind() != reflect.String {
+		return reflect.Value{}, fmt.Errorf("%s的类型为%v，只支持以字符串为键的map", pathName(walked), v.Type())
+	}
+	return reflect.ValueOf(segment.name).Convert(v.Type().Key()), nil
+}
+
+// Walk 按顺序访问obj中的所有值，包括导出的结构体字段（未导出的嵌入结构体中导出的字段作为提升的字段访问）、切片和数组的元素以及以字符串为键的map的值
+// path为GetPath可以使用的路径，map按键排序，为nil的指针不再向下访问
+// fn返回SkipPath时不访问当前值内部，返回其他错误时停止访问并返回该错误
+func Walk(obj interface{}, fn func(path string, value interface{}) error) error {
+	return walkValue(reflect.ValueOf(obj), "", fn)
+}
+
+func walkValue(v reflect.Value, path string, fn func(path string, value interface{}) error) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	visit := func(child reflect.Value, segment pathSegment) error {
+		childPath := joinPath(path, segment)
+		if err := fn(childPath, child.Interface()); err != nil {
+			if err == SkipPath {
+				return nil
+			}
+			return err
+		}
+		return walkValue(child, childPath, fn)
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return nil
+		}
+		for _, field := range GetTypeInfo(v.Type()).fieldList {
+			if field.PkgPath != "" || !walkPromoted(v.Type(), field) {
+				continue
+			}
+			// 嵌入的结构体指针为nil时不访问
+			child, err := fieldValue(v, field, false)
+			if err != nil {
+				continue
+			}
+			if err := visit(child, pathSegment{name: field.Name}); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := visit(v.Index(i), pathSegment{name: strconv.Itoa(i), bracket: true}); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		for _, key := range keys {
+			if err := visit(v.MapIndex(key), pathSegment{name: key.String(), bracket: true}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 是否在外层访问该字段，与GetPath保持一致
+// 导出的嵌入结构体中的字段在嵌入的结构体中访问，未导出的嵌入结构体中的字段作为提升的字段访问
+func walkPromoted(t reflect.Type, field *FieldInfo) bool {
+	for _, index := range field.Index[:len(field.Index)-1] {
+		embedded := t.Field(index)
+		if embedded.PkgPath == "" {
+			return false
+		}
+		t = getReadType(embedded.Type)
+	}
+	return true
+}
+
+// 按路径查找对应的结构体字段，只检查类型，路径以下标结尾时返回切片或map所在的字段
+func fieldByPath(t reflect.Type, path string) (*FieldInfo, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	var field *FieldInfo
+	walked := ""
+	for _, segment := range segments {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		switch {
+		case t.Kind() == reflect.Struct && !segment.bracket:
+			f, ok := GetTypeInfo(t).Fields[segment.name]
+			if !ok {
+				return nil, fmt.Errorf("路径%s: %s的类型%v中不存在字段%s", path, pathName(walked), t, segment.name)
+			}
+			field, t = f, f.Type
+		case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+			if !segment.bracket {
+				return nil, fmt.Errorf("路径%s: %s是%v，需要使用下标访问", path, pathName(walked), t)
+			}
+			t = t.Elem()
+		case t.Kind() == reflect.Map:
+			t = t.Elem()
+		default:
+			return nil, fmt.Errorf("路径%s: %s的类型为%v，不能访问%s", path, pathName(walked), t, joinPath("", segment))
+		}
+		walked = joinPath(walked, segment)
+	}
+	if field == nil {
+		return nil, fmt.Errorf("路径%s中没有结构体字段", path)
+	}
+	return field, nil
+}
@@ -0,0 +1,141 @@
+package vermouth
+
+import (
+	"net/http"
+	"reflect"
+)
+
+// ResponseEnvelope 统一的返回结构，例如 {code, message, data, traceId}
+// 字段名留空则不输出该字段
+type ResponseEnvelope struct {
+	CodeField    string
+	MessageField string
+	DataField    string
+	TraceIdField string
+
+	// 成功时返回的code和message
+	SuccessCode    int
+	SuccessMessage string
+
+	// 从该请求头中读取traceId，没有则自动生成，并写回响应头
+	TraceHeader string
+
+	skipTypes map[reflect.Type]struct{}
+}
+
+var (
+	// 错误总是使用统一的返回结构，未开启统一返回时使用默认结构
+	defaultEnvelope  = NewResponseEnvelope()
+	responseEnvelope *ResponseEnvelope
+)
+
+func NewResponseEnvelope() *ResponseEnvelope {
+	return &ResponseEnvelope{
+		CodeField:      "code",
+		MessageField:   "message",
+		DataField:      "data",
+		TraceIdField:   "traceId",
+		SuccessCode:    0,
+		SuccessMessage: "ok",
+		TraceHeader:    "X-Trace-Id",
+		skipTypes:      map[reflect.Type]struct{}{},
+	}
+}
+
+// SetResponseEnvelope 开启统一返回，控制器的返回值会被包装进data中，传入nil则关闭
+// 单个接口可以通过 raw:"true" 跳过包装
+func SetResponseEnvelope(envelope *ResponseEnvelope) {
+	responseEnvelope = envelope
+}
+
+// Skip 指定的返回值类型不进行包装，直接输出，例如文件下载
+func (e *ResponseEnvelope) Skip(types ...interface{}) *ResponseEnvelope {
+	if e.skipTypes == nil {
+		e.skipTypes = map[reflect.Type]struct{}{}
+	}
+	for _, tp := range types {
+		e.skipTypes[reflect.TypeOf(tp)] = struct{}{}
+	}
+	return e
+}
+
+func (e *ResponseEnvelope) skip(data interface{}) bool {
+	_, ok := e.skipTypes[reflect.TypeOf(data)]
+	return ok
+}
+
+func (e *ResponseEnvelope) wrap(code int, message string, data interface{}, traceId string) map[string]interface{} {
+	body := make(map[string]interface{}, 4)
+	if e.CodeField != "" {
+		body[e.CodeField] = code
+	}
+	if e.MessageField != "" {
+		body[e.MessageField] = message
+	}
+	if e.DataField != "" {
+		body[e.DataField] = data
+	}
+	if e.TraceIdField != "" && traceId != "" {
+		body[e.TraceIdField] = traceId
+	}
+	return body
+}
+
+func currentEnvelope() *ResponseEnvelope {
+	if responseEnvelope != nil {
+		return responseEnvelope
+	}
+	return defaultEnvelope
+}
+
+// TraceId 获取当前请求的traceId
+func (aopContext *Context) TraceId() string {
+	if aopContext.traceId == "" {
+		envelope := currentEnvelope()
+		if envelope.TraceHeader != "" {
+			aopContext.traceId = aopContext.HttpContext.Request().Header.Get(envelope.TraceHeader)
+		}
+		if aopContext.traceId == "" {
+			aopContext.traceId = uuid()
+		}
+		if envelope.TraceHeader != "" {
+			aopContext.HttpContext.Writer().Header().Set(envelope.TraceHeader, aopContext.traceId)
+		}
+	}
+	return aopContext.traceId
+}
+
+// Success 输出成功的结果，开启统一返回时会被包装
+func (aopContext *Context) Success(data interface{}) {
+	envelope := responseEnvelope
+	if envelope == nil || aopContext.ControllerInformation.Raw || envelope.skip(data) {
+		aopContext.JSON(http.StatusOK, data)
+		return
+	}
+	aopContext.JSON(http.StatusOK, envelope.wrap(envelope.SuccessCode, envelope.SuccessMessage, data, aopContext.TraceId()))
+}
+
+// Fail 输出失败的结果，无论是否开启统一返回，错误都使用统一的结构
+func (aopContext *Context) Fail(status int, code int, message string, data interface{}) {
+	aopContext.AutoReturn = false
+	aopContext.JSON(status, currentEnvelope().wrap(code, message, data, aopContext.TraceId()))
+}
+
+// 将控制器的返回值写回前端
+func writeResult(aopContext *Context) {
+	if !aopContext.AutoReturn {
+		return
+	}
+	// 控制器已经自行输出了响应，例如文件下载
+	if aopContext.HttpContext.Written() {
+		return
+	}
+	res := aopContext.Result
+	if err := resultError(res); err != nil {
+		handleError(aopContext, err)
+	} else if len(res) > 0 {
+		aopContext.Success(res[0])
+	} else {
+		aopContext.Success(nil)
+	}
+}
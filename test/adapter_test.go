@@ -0,0 +1,64 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
+)
+
+type HttpController struct {
+	_       interface{}                                                      `path:"/http"`
+	GetUser func(id int, fields []string, ctx *vermouth.Context) interface{} `method:"GET" path:"/users/:id" params:"id=path,fields"`
+	AddUser func(req *Request, w http.ResponseWriter) interface{}            `method:"POST" path:"/users" params:"req"`
+	Files   func(file string, lang string) interface{}                       `method:"GET" path:"/files/*file" params:"file=path,lang=header"`
+}
+
+func NewHttpController() *HttpController {
+	return &HttpController{
+		GetUser: func(id int, fields []string, ctx *vermouth.Context) interface{} {
+			return map[string]interface{}{"id": id, "fields": fields, "path": ctx.ControllerInformation.Path}
+		},
+		AddUser: func(req *Request, w http.ResponseWriter) interface{} {
+			w.Header().Set("X-Sum", "ok")
+			return req.A + req.B
+		},
+		Files: func(file string, lang string) interface{} {
+			return file + ":" + lang
+		},
+	}
+}
+
+func TestServeMuxAdapter(t *testing.T) {
+	mux := http.NewServeMux()
+	assert.NoError(t, vermouth.RegisterControllersE(mux, NewHttpController()))
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("lang", "zh")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("GET", "/http/users/12?fields=name,age", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"id":12,"fields":["name","age"],"path":"/http/users/:id"}`, w.Body.String())
+
+	w = serve("POST", "/http/users", `{"a":1,"b":2}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "3", w.Body.String())
+	assert.Equal(t, "ok", w.Header().Get("X-Sum"))
+
+	w = serve("GET", "/http/files/a/b.txt", "")
+	assert.Equal(t, `"/a/b.txt:zh"`, w.Body.String())
+
+	w = serve("DELETE", "/http/users", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	w = serve("GET", "/http/users/12/more", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
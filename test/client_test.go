@@ -0,0 +1,134 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
+)
+
+type UserClient struct {
+	GetUser func(ctx context.Context, id int, fields []string) (map[string]interface{}, error) `method:"GET" path:"/http/users/:id" params:"id=path,fields"`
+	AddUser func(req *Request) (int, error)                                                    `method:"POST" path:"/http/users" params:"req"`
+	File    func(file string, lang string) string                                              `method:"GET" path:"/http/files/*file" params:"file=path,lang=header"`
+	Missing func() error                                                                       `method:"GET" path:"/http/missing"`
+}
+
+func TestClient(t *testing.T) {
+	mux := http.NewServeMux()
+	vermouth.RegisterControllers(mux, NewHttpController())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var calls int32
+	var svc UserClient
+	_, err := vermouth.NewClient(&svc, server.URL, func(req *http.Request, next func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		return next(req)
+	}, vermouth.HeaderInterceptor("lang", func(req *http.Request) string {
+		return "zh"
+	}))
+	assert.NoError(t, err)
+
+	user, err := svc.GetUser(context.Background(), 12, []string{"name", "age"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": float64(12), "fields": []interface{}{"name", "age"}, "path": "/http/users/:id"}, user)
+
+	sum, err := svc.AddUser(&Request{A: 1, B: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, sum)
+
+	// 没有error返回值时，header参数会被拦截器覆盖
+	assert.Equal(t, "/a/b.txt:zh", svc.File("a/b.txt", "en"))
+
+	err = svc.Missing()
+	if assert.IsType(t, &vermouth.RuntimeError{}, err) {
+		assert.Equal(t, http.StatusNotFound, err.(*vermouth.RuntimeError).Code)
+	}
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
+
+func TestClientRetry(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"code":0,"message":"ok","data":{"a":1,"b":2}}`))
+	}))
+	defer server.Close()
+
+	var svc struct {
+		Get func() (*Request, error) `method:"GET" path:"/"`
+	}
+	client, err := vermouth.NewClient(&svc, server.URL, vermouth.RetryInterceptor(2, time.Millisecond))
+	assert.NoError(t, err)
+	client.Envelope = vermouth.NewResponseEnvelope()
+
+	req, err := svc.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, &Request{A: 1, B: 2}, req)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestClientDefinition(t *testing.T) {
+	var svc struct {
+		NoPath  func() error                `method:"GET"`
+		BadPath func(id int) error          `method:"GET" path:"/users" params:"id=path"`
+		NoName  func(a, b int) error        `method:"GET" path:"/a" params:"a"`
+		Errors  func() (error, interface{}) `method:"GET" path:"/b"`
+	}
+	_, err := vermouth.NewClient(&svc, "http://localhost")
+	de, ok := err.(*vermouth.DefinitionError)
+	if assert.True(t, ok) {
+		assert.Len(t, de.Messages, 4)
+	}
+	assert.Nil(t, svc.NoName)
+}
+
+type ClientRoundTripController struct {
+	_      interface{}                 `path:"/client-trip"`
+	Delete func(req Request) int       `method:"DELETE" path:"/items" params:"req"`
+	Item   func(idx int, id int) []int `method:"GET" path:"/items/:idx/:id" params:"idx=path,id=path"`
+}
+
+type ClientRoundTripClient struct {
+	Delete func(req *Request) (int, error)      `method:"DELETE" path:"/client-trip/items" params:"req"`
+	Item   func(idx int, id int) ([]int, error) `method:"GET" path:"/client-trip/items/:idx/:id" params:"idx=path,id=path"`
+}
+
+func TestClientRoundTrip(t *testing.T) {
+	r := gin.New()
+	vermouth.RegisterControllers(r, &ClientRoundTripController{
+		Delete: func(req Request) int { return req.A + req.B },
+		Item:   func(idx int, id int) []int { return []int{idx, id} },
+	})
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	var svc ClientRoundTripClient
+	_, err := vermouth.NewClient(&svc, server.URL)
+	assert.NoError(t, err)
+	// DELETE请求的结构体参数与服务端一样放在body中
+	sum, err := svc.Delete(&Request{A: 1, B: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, sum)
+	// :id不会替换:idx的前缀
+	item, err := svc.Item(1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, item)
+
+	// 路径参数需要是完整的一段
+	var bad struct {
+		Get func(id int) error `method:"GET" path:"/items/:idx" params:"id=path"`
+	}
+	_, err = vermouth.NewClient(&bad, server.URL)
+	assert.Error(t, err)
+}
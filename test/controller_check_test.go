@@ -0,0 +1,86 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
+)
+
+type BadController struct {
+	_          interface{}                      `path:"/bad"`
+	NoPath     func() interface{}               `method:"GET"`
+	TooMany    func(a int) interface{}          `method:"GET" path:"/many" params:"a,b"`
+	NoName     func(a int, b int) interface{}   `method:"GET" path:"/name" params:"a"`
+	BadType    func(ch chan int) interface{}    `method:"GET" path:"/type" params:"ch"`
+	BadSource  func(a int) interface{}          `method:"GET" path:"/source" params:"a=cookie"`
+	Duplicate  func() interface{}               `method:"GET" path:"/many"`
+	UnknownTag func() interface{}               `method:"GET" path:"/tag" cache:"10s"`
+	Nil        func() interface{}               `method:"GET" path:"/nil"`
+	NotFunc    string                           `method:"GET" path:"/str"`
+	Context    func(c *gin.Context) interface{} `method:"GET" path:"/ctx"`
+}
+
+func NewBadController() *BadController {
+	fn := func() interface{} { return nil }
+	return &BadController{
+		NoPath:     fn,
+		TooMany:    func(a int) interface{} { return a },
+		NoName:     func(a int, b int) interface{} { return a + b },
+		BadType:    func(ch chan int) interface{} { return nil },
+		BadSource:  func(a int) interface{} { return a },
+		Duplicate:  fn,
+		UnknownTag: fn,
+		Context:    func(c *gin.Context) interface{} { return nil },
+	}
+}
+
+func TestRegisterControllersE(t *testing.T) {
+	r := gin.New()
+	err := vermouth.RegisterControllersE(r, NewBadController())
+	de, ok := err.(*vermouth.DefinitionError)
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.ElementsMatch(t, []string{
+		`BadController.NoPath: missing path tag`,
+		`BadController.NotFunc: routed field must be a func, got string`,
+		`BadController.UnknownTag: unknown tag "cache"`,
+		`BadController.TooMany: params declares 2 names but func takes 1 arguments`,
+		`BadController.NoName: argument #1 (int) has no name in params tag`,
+		`BadController.BadType: argument #0 ch has unsupported type chan int`,
+		`BadController.BadSource: param a has unsupported source "cookie"`,
+		`BadController.Duplicate: duplicate route GET /bad/many, already registered by BadController.TooMany`,
+		`BadController.Nil: func is nil`,
+	}, de.Messages)
+	// 有问题时不注册任何接口
+	assert.Empty(t, r.Routes())
+
+	// 声明自定义tag后不再报错
+	vermouth.RegisterTagKeys("cache")
+
+	// 与已注册的接口重复
+	r = gin.New()
+	assert.NoError(t, vermouth.RegisterControllersE(r, NewTestController(), NewErrorController()))
+	err = vermouth.RegisterControllersE(r, NewErrorController())
+	assert.Contains(t, err.Error(), "duplicate route GET /error/runtime, already registered by ErrorController.Runtime")
+
+	// 同一个engine的不同分组，按完整路径检查重复
+	r = gin.New()
+	assert.NoError(t, vermouth.RegisterControllersE(r.Group("/api"), NewErrorController()))
+	assert.NoError(t, vermouth.RegisterControllersE(r.Group("/v2"), NewErrorController()))
+	err = vermouth.RegisterControllersE(r.Group("/api"), NewErrorController())
+	assert.Contains(t, err.Error(), "duplicate route GET /api/error/runtime, already registered by ErrorController.Runtime")
+	err = vermouth.RegisterControllersE(r, NewErrorController())
+	assert.NoError(t, err)
+	err = vermouth.RegisterControllersE(r.Group("/"), NewErrorController())
+	assert.Contains(t, err.Error(), "duplicate route GET /error/runtime, already registered by ErrorController.Runtime")
+
+	err = vermouth.RegisterControllersE(&http.Server{}, NewTestController())
+	assert.Contains(t, err.Error(), "unsupported router type *http.Server")
+	assert.Panics(t, func() {
+		vermouth.RegisterControllers(&http.Server{}, NewTestController())
+	})
+}
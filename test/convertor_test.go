@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
 	// "github.com/modern-go/reflect2"
 )
 
@@ -46,3 +47,21 @@ func TestDynamicConvert(t *testing.T) {
 	vermouth.Convert(&a, &b)
 	fmt.Println(b)
 }
+
+type convertSource struct {
+	UserName string `json:"user_name"`
+	Age      int
+}
+
+type convertTarget struct {
+	Name string `json:"user_name"`
+	Age  int
+}
+
+func TestConvertByTag(t *testing.T) {
+	src := &convertSource{UserName: "alice", Age: 18}
+	dst := &convertTarget{}
+	vermouth.Convert(src, dst)
+	// 字段名不同时按json标签匹配
+	assert.Equal(t, &convertTarget{Name: "alice", Age: 18}, dst)
+}
@@ -0,0 +1,256 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
+)
+
+type CoverCanaryController struct {
+	_   interface{}   `path:"/cover-canary"`
+	New func() string `method:"GET" path:"/new" cover_url:"/cover-canary/old" cover_mode:"canary" cover_percent:"100"`
+}
+
+func TestCoverUrlCanary(t *testing.T) {
+	rollback := make(chan vermouth.CoverRouteInfo, 1)
+	var oldCalls int32
+	r := gin.New()
+	cover := vermouth.NewCoverUrl(vermouth.CoverUrlConfig{
+		Engine: r,
+		Canary: vermouth.CoverCanaryConfig{
+			MinRequests:     3,
+			MaxMismatchRate: 0.5,
+			OnRollback: func(info vermouth.CoverRouteInfo) {
+				rollback <- info
+			},
+		},
+	})
+	defer cover.Close()
+	r.Use(cover.Middleware())
+	vermouth.RegisterControllers(r, &CoverCanaryController{New: func() string { return "new" }})
+	r.GET("/cover-canary/old", func(c *gin.Context) {
+		atomic.AddInt32(&oldCalls, 1)
+		c.JSON(http.StatusOK, "old")
+	})
+
+	info, ok := vermouth.LookupCoverRoute("/cover-canary/old")
+	assert.True(t, ok)
+	assert.Equal(t, vermouth.CoverRouteInfo{Route: "/cover-canary/old", Path: "/cover-canary/new", Mode: vermouth.CoverCanary, Percent: 100, Health: vermouth.CoverHealthy}, info)
+
+	// 新接口处理线上的请求，旧接口在后台比较
+	get := func() string {
+		req, _ := http.NewRequest("GET", "/cover-canary/old", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+	for i := 1; i <= 3; i++ {
+		assert.Equal(t, `"new"`, get())
+		waitFor(t, func() bool { return atomic.LoadInt32(&oldCalls) == int32(i) && cover.Stats().Mismatched == int64(i) })
+	}
+
+	// 不一致的比例超过阈值，自动回滚
+	waitFor(t, func() bool {
+		info, _ = vermouth.LookupCoverRoute("/cover-canary/old")
+		return info.Health == vermouth.CoverRolledBack
+	})
+	assert.Equal(t, 0.0, info.Percent)
+	assert.Equal(t, vermouth.CoverWindowStats{Total: 3, Mismatched: 3}, info.Window)
+	assert.Equal(t, "mismatch rate 100.00% exceeds 50.00%", info.Reason)
+	assert.Equal(t, info, <-rollback)
+	assert.Equal(t, `"old"`, get())
+
+	// 修复后重新开始
+	assert.NoError(t, vermouth.SetCoverPercent("/cover-canary/old", 100))
+	info, _ = vermouth.LookupCoverRoute("/cover-canary/old")
+	assert.Equal(t, vermouth.CoverHealthy, info.Health)
+	assert.Equal(t, vermouth.CoverWindowStats{}, info.Window)
+	assert.Equal(t, `"new"`, get())
+
+	assert.Error(t, vermouth.SetCoverPercent("/cover-canary/old", 101))
+	assert.Error(t, vermouth.SetCoverPercent("/cover-canary/none", 10))
+}
+
+type CoverCanaryReplayController struct {
+	_   interface{}   `path:"/cover-canary-replay"`
+	New func() string `method:"GET" path:"/new" cover_url:"/cover-canary-replay/old" cover_mode:"canary" cover_percent:"100"`
+}
+
+func TestCoverUrlCanaryReplay(t *testing.T) {
+	var oldCalls int32
+	sink := vermouth.NewMemorySink(10)
+	r := gin.New()
+	cover := vermouth.NewCoverUrl(vermouth.CoverUrlConfig{
+		Engine: r,
+		Sinks:  []vermouth.MismatchSink{sink},
+		Canary: vermouth.CoverCanaryConfig{MinRequests: 100},
+	})
+	defer cover.Close()
+	r.Use(cover.Middleware())
+	vermouth.RegisterControllers(r, &CoverCanaryReplayController{New: func() string { return "new" }})
+	r.GET("/cover-canary-replay/old", func(c *gin.Context) {
+		atomic.AddInt32(&oldCalls, 1)
+		c.JSON(http.StatusOK, "old")
+	})
+
+	req, _ := http.NewRequest("GET", "/cover-canary-replay/old?id=1", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	waitFor(t, func() bool { return sink.Len() == 1 })
+	// 交换过时日志中仍记录新接口的地址
+	event := sink.Events()[0]
+	assert.Equal(t, "/cover-canary-replay/new?id=1", event.Request.URL)
+	assert.Equal(t, `"old"`, event.OriginalResponse)
+	assert.Equal(t, `"new"`, event.DuplicateResponse)
+
+	// 重放请求新接口，与旧接口的结果比较
+	server := httptest.NewServer(r)
+	defer server.Close()
+	results := vermouth.ReplayCoverLogs([]*vermouth.CoverLog{{CoverMismatch: *event}}, server.URL, nil)
+	if assert.Len(t, results, 1) {
+		assert.NoError(t, results[0].Err)
+		assert.Equal(t, `"new"`, results[0].Response)
+		assert.False(t, results[0].Fixed())
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&oldCalls))
+}
+
+type CoverCanaryMethodController struct {
+	_      interface{}   `path:"/cover-canary-method"`
+	Create func() string `method:"POST" path:"/new" cover_url:"/cover-canary-method/old" cover_mode:"canary" cover_percent:"100"`
+}
+
+func TestCoverUrlCanaryMethod(t *testing.T) {
+	r := gin.New()
+	cover := vermouth.NewCoverUrl(vermouth.CoverUrlConfig{Engine: r})
+	defer cover.Close()
+	r.Use(cover.Middleware())
+	vermouth.RegisterControllers(r, &CoverCanaryMethodController{Create: func() string { return "new" }})
+	r.POST("/cover-canary-method/old", func(c *gin.Context) {
+		c.JSON(http.StatusOK, "old")
+	})
+
+	// 默认只允许GET，POST请求不比较，也不交给新接口处理
+	req, _ := http.NewRequest("POST", "/cover-canary-method/old", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, `"old"`, w.Body.String())
+	assert.Equal(t, vermouth.CoverUrlStats{Skipped: 1}, cover.Stats())
+}
+
+type CoverCanaryRemoteController struct {
+	_    interface{}   `path:"/cover-canary-remote"`
+	List func() []int  `method:"GET" path:"/list" cover_url:"/legacy/list" cover_target:"legacy" cover_mode:"canary" cover_percent:"0"`
+	Fail func() string `method:"GET" path:"/fail" cover_url:"/legacy/fail" cover_target:"legacy" cover_mode:"canary" cover_percent:"100"`
+}
+
+func TestCoverUrlCanaryRemote(t *testing.T) {
+	legacy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Legacy", "1")
+		w.Write([]byte(`[1,2,4]`))
+	}))
+	defer legacy.Close()
+
+	sink := vermouth.NewMemorySink(10)
+	r := gin.New()
+	cover := vermouth.NewCoverUrl(vermouth.CoverUrlConfig{
+		Engine:  r,
+		Targets: map[string]string{"legacy": legacy.URL},
+		Sinks:   []vermouth.MismatchSink{sink},
+		Canary:  vermouth.CoverCanaryConfig{MinRequests: 1},
+	})
+	defer cover.Close()
+	r.Use(cover.Middleware())
+	vermouth.RegisterControllers(r, &CoverCanaryRemoteController{
+		List: func() []int { return []int{1, 2, 3} },
+		Fail: func() string { panic("boom") },
+	})
+
+	// 新接口没有接管流量时，由远程的旧接口处理，新接口在后台比较
+	req, _ := http.NewRequest("GET", "/cover-canary-remote/list", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, "[1,2,4]", w.Body.String())
+	assert.Equal(t, "1", w.Header().Get("X-Legacy"))
+	waitFor(t, func() bool { return sink.Len() == 1 })
+	event := sink.Events()[0]
+	assert.Equal(t, "[1,2,3]", event.OriginalResponse)
+	assert.Equal(t, "[1,2,4]", event.DuplicateResponse)
+
+	// 新接口出错的比例超过阈值，自动回滚
+	req, _ = http.NewRequest("GET", "/cover-canary-remote/fail", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	waitFor(t, func() bool {
+		info, _ := vermouth.LookupCoverRoute("/cover-canary-remote/fail")
+		return info.Health == vermouth.CoverRolledBack
+	})
+	info, _ := vermouth.LookupCoverRoute("/cover-canary-remote/fail")
+	assert.Equal(t, "error rate 100.00% exceeds 1.00%", info.Reason)
+
+	routes := vermouth.CoverRoutes()
+	var found bool
+	for _, route := range routes {
+		if route.Route == "/cover-canary-remote/list" {
+			found = true
+			assert.Equal(t, vermouth.CoverCanary, route.Mode)
+			assert.Equal(t, "legacy", route.Target)
+		}
+	}
+	assert.True(t, found)
+}
+
+type CoverCanaryLegacyController struct {
+	_    interface{}  `path:"/cover-canary-legacy"`
+	List func() []int `method:"GET" path:"/list" cover_url:"/legacy/down" cover_target:"legacy" cover_mode:"canary" cover_percent:"0"`
+}
+
+func TestCoverUrlCanaryLegacyDown(t *testing.T) {
+	// 远程的旧接口不可用
+	legacy := httptest.NewServer(http.NotFoundHandler())
+	legacy.Close()
+
+	r := gin.New()
+	cover := vermouth.NewCoverUrl(vermouth.CoverUrlConfig{
+		Engine:  r,
+		Targets: map[string]string{"legacy": legacy.URL},
+		Canary:  vermouth.CoverCanaryConfig{MinRequests: 1},
+	})
+	defer cover.Close()
+	r.Use(cover.Middleware())
+	vermouth.RegisterControllers(r, &CoverCanaryLegacyController{List: func() []int { return []int{1} }})
+
+	// 旧接口失败时由新接口处理，不计入新接口的失败，也不会回滚
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", "/cover-canary-legacy/list", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, "[1]", w.Body.String())
+	}
+	info, _ := vermouth.LookupCoverRoute("/cover-canary-legacy/list")
+	assert.Equal(t, vermouth.CoverHealthy, info.Health)
+	assert.Equal(t, vermouth.CoverWindowStats{}, info.Window)
+}
+
+type CoverCanaryInvalidController struct {
+	_ interface{}   `path:"/cover-canary-invalid"`
+	A func() string `method:"GET" path:"/a" cover_url:"/cover-canary-invalid/x" cover_mode:"blue"`
+	B func() string `method:"GET" path:"/b" cover_url:"/cover-canary-invalid/y" cover_percent:"10"`
+	C func() string `method:"GET" path:"/c" cover_url:"/cover-canary-invalid/z" cover_mode:"canary" cover_percent:"200"`
+}
+
+func TestCoverUrlCanaryDefinition(t *testing.T) {
+	err := vermouth.RegisterControllersE(gin.New(), &CoverCanaryInvalidController{
+		A: func() string { return "" },
+		B: func() string { return "" },
+		C: func() string { return "" },
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `invalid cover_mode "blue"`)
+		assert.Contains(t, err.Error(), `cover_percent requires cover_mode "canary"`)
+		assert.Contains(t, err.Error(), `invalid cover_percent "200"`)
+	}
+}
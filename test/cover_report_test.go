@@ -0,0 +1,110 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeCoverLog(t *testing.T, dir, name string, data map[string]interface{}) {
+	content, _ := json.Marshal(data)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, name), content, 0644))
+}
+
+func TestCoverReport(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		writeCoverLog(t, dir, fmt.Sprintf("2024-01-01_00-00-0%d_a.json", i), map[string]interface{}{
+			"request":            map[string]interface{}{"method": "GET", "url": "/api/new/users?page=1", "query": "page=1"},
+			"route":              "/api/old/users",
+			"original_response":  `{"list":[{"id":1},{"id":2}]}`,
+			"duplicate_response": fmt.Sprintf(`{"list":[{"id":1},{"id":%d}]}`, 3+i),
+			"diff":               []vermouth.JSONDiff{{Path: fmt.Sprintf("list.%d.id", i), Kind: "changed"}},
+		})
+	}
+	// 旧的日志没有route和diff
+	writeCoverLog(t, dir, "2024-01-01_00-00-03_b.json", map[string]interface{}{
+		"request":            map[string]interface{}{"method": "GET", "url": "http://localhost/api/new/orders"},
+		"original_response":  `{"total":1}`,
+		"duplicate_response": `{"total":2}`,
+	})
+	stats, _ := json.Marshal(map[string]vermouth.CoverUrlStats{"/api/old/users": {Matched: 6, Mismatched: 2}, "/api/old/items": {Matched: 3}})
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "cover_stats.json"), stats, 0644))
+
+	report, err := vermouth.BuildCoverReport(dir)
+	assert.NoError(t, err)
+	if assert.Len(t, report.Routes, 3) {
+		users := report.Routes[0]
+		assert.Equal(t, "/api/old/users", users.Route)
+		assert.Equal(t, 2, users.Mismatched)
+		assert.Equal(t, 0.25, users.Rate)
+		if assert.Len(t, users.Groups, 1) {
+			assert.Equal(t, 2, users.Groups[0].Count)
+			assert.Equal(t, []string{"changed list.*.id"}, users.Groups[0].Paths)
+		}
+		assert.Equal(t, "/api/new/orders", report.Routes[1].Route)
+		assert.Equal(t, -1.0, report.Routes[1].Rate)
+		assert.Equal(t, []string{"changed total"}, report.Routes[1].Groups[0].Paths)
+		assert.Equal(t, "/api/old/items", report.Routes[2].Route)
+		assert.Equal(t, 0.0, report.Routes[2].Rate)
+	}
+	assert.Contains(t, report.Markdown(), "| /api/old/users |  | 8 | 2 | 25.00% | 0 | 0 |")
+	assert.Contains(t, report.HTML(), "<td>changed list.*.id<br></td>")
+
+	// 重放：修复后的接口与原结果一致
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/new/users") {
+			assert.Equal(t, "page=1", r.URL.RawQuery)
+			w.Write([]byte(`{"list":[{"id":1},{"id":2}]}`))
+			return
+		}
+		w.Write([]byte(`{"total":3}`))
+	}))
+	defer server.Close()
+	logs, err := vermouth.LoadCoverLogs(dir)
+	assert.NoError(t, err)
+	results := vermouth.ReplayCoverLogs(logs, server.URL, nil)
+	if assert.Len(t, results, 3) {
+		assert.True(t, results[0].Fixed())
+		assert.True(t, results[1].Fixed())
+		assert.False(t, results[2].Fixed())
+		assert.Equal(t, []vermouth.JSONDiff{{Path: "total", Kind: "changed", Original: json.Number("1"), Duplicate: json.Number("3")}}, results[2].Diff)
+	}
+}
+
+type CoverStatsController struct {
+	_   interface{}   `path:"/cover-stats"`
+	New func() string `method:"GET" path:"/new" cover_url:"/cover-stats/old"`
+}
+
+func TestCoverUrlWriteStats(t *testing.T) {
+	dir := t.TempDir()
+	r := gin.New()
+	cover := vermouth.NewCoverUrl(vermouth.CoverUrlConfig{LogPath: dir, Engine: r})
+	r.Use(cover.Middleware())
+	vermouth.RegisterControllers(r, &CoverStatsController{New: func() string { return "new" }})
+	r.GET("/cover-stats/old", func(c *gin.Context) {
+		c.JSON(http.StatusOK, "old")
+	})
+	req, _ := http.NewRequest("GET", "/cover-stats/old", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	waitFor(t, func() bool { return cover.Stats().Mismatched == 1 })
+
+	// 多次写入不会重复累加
+	assert.NoError(t, cover.WriteStats())
+	cover.Close()
+	data, err := os.ReadFile(filepath.Join(dir, "cover_stats.json"))
+	assert.NoError(t, err)
+	var stats map[string]vermouth.CoverUrlStats
+	assert.NoError(t, json.Unmarshal(data, &stats))
+	assert.Equal(t, vermouth.CoverUrlStats{Sampled: 1, Mismatched: 1}, stats["/cover-stats/old"])
+}
@@ -0,0 +1,186 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
+)
+
+type CoverSinkController struct {
+	_   interface{}   `path:"/cover-sink"`
+	New func() string `method:"GET" path:"/new" cover_url:"/cover-sink/old"`
+}
+
+func TestCoverUrlSinks(t *testing.T) {
+	memory := vermouth.NewMemorySink(2)
+	var called int
+	r := gin.New()
+	cover := vermouth.NewCoverUrl(vermouth.CoverUrlConfig{
+		Engine: r,
+		Sinks: []vermouth.MismatchSink{memory, vermouth.MismatchSinkFunc(func(event *vermouth.CoverMismatch) error {
+			called++
+			return nil
+		})},
+		// 只有一个worker，回调不需要处理并发
+		Workers: 1,
+	})
+	r.Use(cover.Middleware())
+	vermouth.RegisterControllers(r, &CoverSinkController{New: func() string {
+		time.Sleep(time.Millisecond)
+		return "new"
+	}})
+	r.GET("/cover-sink/old", func(c *gin.Context) {
+		c.Header("X-Version", "old")
+		c.JSON(http.StatusAccepted, "old")
+	})
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", "/cover-sink/old?i=1", nil)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	cover.Close()
+
+	// 只保留最近的两条
+	assert.Equal(t, 3, called)
+	assert.Equal(t, int64(3), memory.Total())
+	events := memory.Events()
+	if assert.Len(t, events, 2) {
+		event := events[1]
+		assert.Equal(t, "/cover-sink/old", event.Route)
+		assert.Equal(t, "i=1", event.Request.Query)
+		assert.Equal(t, http.StatusAccepted, event.Original.Status)
+		assert.Equal(t, "old", event.Original.Headers.Get("X-Version"))
+		assert.Equal(t, http.StatusOK, event.Duplicate.Status)
+		assert.Equal(t, "application/json; charset=utf-8", event.Duplicate.Headers.Get("Content-Type"))
+		assert.True(t, event.Duplicate.Latency >= time.Millisecond)
+		assert.True(t, event.Original.Latency > 0)
+		assert.Equal(t, `"old"`, event.OriginalResponse)
+		assert.Equal(t, `"new"`, event.DuplicateResponse)
+	}
+	memory.Reset()
+	assert.Equal(t, 0, memory.Len())
+}
+
+type CoverRedactController struct {
+	_   interface{}   `path:"/cover-redact"`
+	New func() string `method:"GET" path:"/new" cover_url:"/cover-redact/old"`
+}
+
+func TestCoverUrlRedactHeaders(t *testing.T) {
+	memory := vermouth.NewMemorySink(1)
+	r := gin.New()
+	cover := vermouth.NewCoverUrl(vermouth.CoverUrlConfig{Engine: r, Sinks: []vermouth.MismatchSink{memory}})
+	r.Use(cover.Middleware())
+	vermouth.RegisterControllers(r, &CoverRedactController{New: func() string { return "new" }})
+	r.GET("/cover-redact/old", func(c *gin.Context) {
+		c.Header("Set-Cookie", "session=secret")
+		c.JSON(http.StatusOK, "old")
+	})
+	req, _ := http.NewRequest("GET", "/cover-redact/old", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("x-api-key", "secret")
+	req.Header.Set("X-Trace", "trace-1")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	cover.Close()
+
+	events := memory.Events()
+	if !assert.Len(t, events, 1) {
+		return
+	}
+	event := events[0]
+	// 写入sink前已经脱敏，其他header保持不变
+	for _, name := range []string{"Authorization", "Cookie", "X-Api-Key"} {
+		assert.Equal(t, []string{vermouth.RedactedHeaderValue}, event.Request.Headers.Values(name), name)
+	}
+	assert.Equal(t, "trace-1", event.Request.Headers.Get("X-Trace"))
+	assert.Equal(t, vermouth.RedactedHeaderValue, event.Original.Headers.Get("Set-Cookie"))
+	data, _ := json.Marshal(event)
+	assert.NotContains(t, string(data), "secret")
+
+	// 重放时不发送脱敏的header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Authorization"))
+		assert.Equal(t, "trace-1", r.Header.Get("X-Trace"))
+		w.Write([]byte(`"old"`))
+	}))
+	defer server.Close()
+	results := vermouth.ReplayCoverLogs([]*vermouth.CoverLog{{CoverMismatch: *event}}, server.URL, nil)
+	assert.True(t, results[0].Fixed())
+}
+
+func TestRotatingFileSink(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "cover.ndjson")
+	sink, err := vermouth.NewRotatingFileSink(vermouth.RotatingFileConfig{Filename: filename, MaxSize: 1000, MaxBackups: 2})
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, sink.Write(&vermouth.CoverMismatch{
+			Route:             "/api/users",
+			Time:              time.Now(),
+			OriginalResponse:  `{"id":1}`,
+			DuplicateResponse: `{"id":2}`,
+		}))
+	}
+	assert.NoError(t, sink.Close())
+	assert.Error(t, sink.Write(&vermouth.CoverMismatch{}))
+
+	// 当前文件和最多两个历史文件，每个文件都不超过MaxSize
+	files, _ := filepath.Glob(filepath.Join(dir, "cover*.ndjson"))
+	assert.Len(t, files, 3)
+	for _, file := range files {
+		info, err := os.Stat(file)
+		assert.NoError(t, err)
+		assert.True(t, info.Size() <= 1000, file)
+	}
+	logs, err := vermouth.LoadCoverLogs(dir)
+	assert.NoError(t, err)
+	assert.True(t, len(logs) > 0 && len(logs) < 10)
+	for _, log := range logs {
+		assert.Equal(t, "/api/users", log.Route)
+		assert.Equal(t, []vermouth.JSONDiff{{Path: "id", Kind: "changed", Original: json.Number("1"), Duplicate: json.Number("2")}}, log.Diff)
+	}
+
+	// 按时间轮转
+	sink, err = vermouth.NewRotatingFileSink(vermouth.RotatingFileConfig{Filename: filename, MaxAge: time.Millisecond})
+	assert.NoError(t, err)
+	time.Sleep(2 * time.Millisecond)
+	assert.NoError(t, sink.Write(&vermouth.CoverMismatch{Route: "/api/users"}))
+	assert.NoError(t, sink.Close())
+	files, _ = filepath.Glob(filepath.Join(dir, "cover-*.ndjson"))
+	assert.Len(t, files, 3)
+}
+
+func TestRotatingFileSinkRecover(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "cover.ndjson")
+	sink, err := vermouth.NewRotatingFileSink(vermouth.RotatingFileConfig{Filename: filename, MaxBackups: 2})
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	// 当前文件被删除时轮转失败，之后仍然可以写入
+	assert.NoError(t, os.Remove(filename))
+	assert.Error(t, sink.Rotate())
+	assert.NoError(t, sink.Write(&vermouth.CoverMismatch{Route: "/api/users"}))
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"route":"/api/users"`)
+
+	// 同一毫秒内的历史文件按序号排序，删除最早的
+	for _, name := range []string{"cover-20240101T000000.000.ndjson", "cover-20240101T000000.000-1.ndjson", "cover-20240101T000000.000-2.ndjson"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+	assert.NoError(t, sink.Rotate())
+	files, _ := filepath.Glob(filepath.Join(dir, "cover-*.ndjson"))
+	if assert.Len(t, files, 2) {
+		assert.Equal(t, filepath.Join(dir, "cover-20240101T000000.000-2.ndjson"), files[0])
+		assert.NotContains(t, files[1], "20240101")
+	}
+}
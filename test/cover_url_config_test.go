@@ -0,0 +1,139 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
+)
+
+type CoverConfigController struct {
+	_      interface{}                      `path:"/cover-config"`
+	Write  func(ctx context.Context) string `method:"POST" path:"/new/write" cover_url:"/cover-config/old/write" cover_methods:"POST" cover_rollback:"true"`
+	Post   func() string                    `method:"POST" path:"/new/post" cover_url:"/cover-config/old/post"`
+	Sample func() string                    `method:"GET" path:"/new/sample" cover_url:"/cover-config/old/sample" cover_sample:"0"`
+	Slow   func() string                    `method:"GET" path:"/new/slow" cover_url:"/cover-config/old/slow"`
+}
+
+func TestCoverUrlConfig(t *testing.T) {
+	db, fake := newFakeDB(t)
+	old := vermouth.GetDB()
+	vermouth.SetDB(db)
+	defer vermouth.SetDB(old)
+	var dao struct {
+		Insert func(ctx context.Context) (int64, error) `sql:"INSERT INTO logs"`
+	}
+	assert.NoError(t, vermouth.NewMapper(&dao))
+
+	cover := vermouth.NewCoverUrl(vermouth.CoverUrlConfig{
+		LogPath:   t.TempDir(),
+		Workers:   1,
+		QueueSize: 1,
+		Timeout:   100 * time.Millisecond,
+	})
+	release := make(chan struct{})
+	r := gin.New()
+	r.Use(cover.Middleware())
+	vermouth.RegisterControllers(r, &CoverConfigController{
+		Write: func(ctx context.Context) string {
+			dao.Insert(ctx)
+			return "ok"
+		},
+		Post:   func() string { return "ok" },
+		Sample: func() string { return "ok" },
+		Slow: func() string {
+			<-release
+			return "ok"
+		},
+	})
+	ok := func(c *gin.Context) {
+		c.JSON(http.StatusOK, "ok")
+	}
+	r.POST("/cover-config/old/write", ok)
+	r.POST("/cover-config/old/post", ok)
+	r.GET("/cover-config/old/sample", ok)
+	r.GET("/cover-config/old/slow", ok)
+	server := httptest.NewServer(r)
+	defer server.Close()
+	defer close(release)
+
+	send := func(method, path string, header http.Header) {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader("{}"))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+	}
+
+	// 写接口在总是回滚的事务中执行
+	send("POST", "/cover-config/old/write", nil)
+	waitFor(t, func() bool { return cover.Stats().Matched == 1 })
+	assert.Equal(t, []string{"BEGIN", "tx: INSERT INTO logs", "ROLLBACK"}, fake.Ops())
+
+	// 外部伪造的header不会生效
+	fake.Reset()
+	send("POST", "/cover-config/new/write", http.Header{"X-Vermouth-Cover-Rollback": {"fake"}})
+	assert.Equal(t, []string{"INSERT INTO logs"}, fake.Ops())
+
+	// 默认只转发GET请求，采样率为0时不转发
+	send("POST", "/cover-config/old/post", nil)
+	send("GET", "/cover-config/old/sample", nil)
+	assert.Equal(t, int64(2), cover.Stats().Skipped)
+
+	// 队列满了之后丢弃，超时的请求记为失败
+	for i := 0; i < 3; i++ {
+		send("GET", "/cover-config/old/slow", nil)
+	}
+	stats := cover.Stats()
+	assert.Equal(t, int64(4), stats.Sampled+stats.Dropped)
+	assert.True(t, stats.Dropped >= 1)
+	waitFor(t, func() bool { return cover.Stats().Failed == stats.Sampled-1 })
+	cover.Close()
+}
+
+func waitFor(t *testing.T, fn func() bool) {
+	for i := 0; i < 100; i++ {
+		if fn() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not met")
+}
+
+type CoverClosedController struct {
+	_   interface{}   `path:"/cover-closed"`
+	Get func() string `method:"GET" path:"/new/get" cover_url:"/cover-closed/old/get"`
+}
+
+func TestCoverUrlAfterClose(t *testing.T) {
+	sink := vermouth.NewMemorySink(10)
+	r := gin.New()
+	cover := vermouth.NewCoverUrl(vermouth.CoverUrlConfig{Sinks: []vermouth.MismatchSink{sink}, Engine: r})
+	r.Use(cover.Middleware())
+	vermouth.RegisterControllers(r, &CoverClosedController{
+		Get: func() string { return "new" },
+	})
+	r.GET("/cover-closed/old/get", func(c *gin.Context) {
+		c.JSON(http.StatusOK, "old")
+	})
+	cover.Close()
+
+	// 关闭之后不再转发，也不能panic
+	req, _ := http.NewRequest("GET", "/cover-closed/old/get", nil)
+	w := httptest.NewRecorder()
+	assert.NotPanics(t, func() { r.ServeHTTP(w, req) })
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"old"`, w.Body.String())
+	assert.Equal(t, vermouth.CoverUrlStats{}, cover.Stats())
+	assert.Empty(t, sink.Events())
+}
@@ -0,0 +1,53 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
+)
+
+// 两个接口互相覆盖，转发的请求不会再次转发
+type CoverEngineController struct {
+	_ interface{}                 `path:"/cover-engine"`
+	A func(c *gin.Context) string `method:"GET" path:"/a" cover_url:"/cover-engine/b"`
+	B func(c *gin.Context) string `method:"GET" path:"/b" cover_url:"/cover-engine/a"`
+}
+
+func TestCoverUrlEngine(t *testing.T) {
+	r := gin.New()
+	cover := vermouth.NewCoverUrl(vermouth.CoverUrlConfig{LogPath: t.TempDir(), Engine: r})
+	r.Use(cover.Middleware())
+
+	var calls int32
+	var host string
+	vermouth.RegisterControllers(r, &CoverEngineController{
+		A: func(c *gin.Context) string {
+			atomic.AddInt32(&calls, 1)
+			host = c.Request.Host
+			return "a"
+		},
+		B: func(c *gin.Context) string {
+			atomic.AddInt32(&calls, 1)
+			return "b"
+		},
+	})
+
+	// 不需要启动服务，转发的请求直接交给engine处理
+	req, _ := http.NewRequest("GET", "http://internal.example.com/cover-engine/b?x=1", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, `"b"`, w.Body.String())
+
+	waitFor(t, func() bool { return cover.Stats().Mismatched == 1 })
+	time.Sleep(20 * time.Millisecond)
+	cover.Close()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, "internal.example.com", host)
+	assert.Equal(t, int64(1), cover.Stats().Sampled)
+}
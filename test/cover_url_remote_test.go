@@ -0,0 +1,89 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
+)
+
+type CoverRemoteController struct {
+	_     interface{}   `path:"/cover-remote"`
+	Users func() []int  `method:"GET" path:"/users" cover_url:"/legacy/users" cover_target:"legacy"`
+	Slow  func() string `method:"GET" path:"/slow" cover_url:"/legacy/slow" cover_target:"legacy"`
+}
+
+func TestCoverUrlRemote(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	legacy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/legacy/slow" {
+			time.Sleep(200 * time.Millisecond)
+		} else {
+			h := r.Header.Clone()
+			h.Set("Host", r.Host)
+			headers <- h
+		}
+		w.Write([]byte(`[1,2,4]`))
+	}))
+	defer legacy.Close()
+
+	logPath := t.TempDir()
+	r := gin.New()
+	cover := vermouth.NewCoverUrl(vermouth.CoverUrlConfig{
+		LogPath:       logPath,
+		Engine:        r,
+		Targets:       map[string]string{"legacy": legacy.URL},
+		RemoteTimeout: 50 * time.Millisecond,
+		RemoteHeaders: vermouth.CoverHeaderRule{
+			Strip: []string{"Authorization"},
+			Set:   map[string]string{"X-Legacy-Token": "secret"},
+		},
+	})
+	r.Use(cover.Middleware())
+	vermouth.RegisterControllers(r, &CoverRemoteController{
+		Users: func() []int { return []int{1, 2, 3} },
+		Slow:  func() string { return "ok" },
+	})
+
+	// 请求新接口时，转发一份到旧系统
+	req, _ := http.NewRequest("GET", "/cover-remote/users?page=1", nil)
+	req.Header.Set("Authorization", "Bearer user")
+	req.Header.Set("X-Request-Id", "1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, "[1,2,3]", w.Body.String())
+
+	h := <-headers
+	assert.Empty(t, h.Get("Authorization"))
+	assert.Equal(t, "secret", h.Get("X-Legacy-Token"))
+	assert.Equal(t, "1", h.Get("X-Request-Id"))
+	assert.Equal(t, legacy.Listener.Addr().String(), h.Get("Host"))
+
+	waitFor(t, func() bool { return cover.Stats().Mismatched == 1 })
+	var files []string
+	waitFor(t, func() bool {
+		files, _ = filepath.Glob(filepath.Join(logPath, "*.json"))
+		return len(files) == 1
+	})
+	data, _ := os.ReadFile(files[0])
+	var log struct {
+		Target string              `json:"target"`
+		Diff   []vermouth.JSONDiff `json:"diff"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &log))
+	assert.Equal(t, "legacy", log.Target)
+	assert.Equal(t, []vermouth.JSONDiff{{Path: "2", Kind: "changed", Original: float64(3), Duplicate: float64(4)}}, log.Diff)
+
+	// 远程服务使用单独的超时时间
+	req, _ = http.NewRequest("GET", "/cover-remote/slow", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	waitFor(t, func() bool { return cover.Stats().Failed == 1 })
+	cover.Close()
+}
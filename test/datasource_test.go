@@ -0,0 +1,104 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
+)
+
+type OrderDao struct {
+	Insert func(ctx context.Context, name string) (int64, error) `sql:"INSERT INTO orders (name) VALUES (#{name})" db:"orders"`
+}
+
+type DataSourceController struct {
+	_      interface{}                                               `path:"/datasource"`
+	Order  func(ctx context.Context, orders *sql.Tx, report *sql.DB) `method:"POST" path:"/order" params:"ctx,orders,report" transaction:"db=orders,isolation=serializable,readonly"`
+	Report func(tx *sql.Tx, report *sql.DB) bool                     `method:"GET" path:"/report" params:"tx,report"`
+}
+
+func TestNamedDataSource(t *testing.T) {
+	ordersDB, orders := newNamedFakeDB(t, "orders")
+	reportDB, report := newNamedFakeDB(t, "report")
+	vermouth.SetNamedDB("orders", ordersDB)
+	vermouth.SetNamedDB("report", reportDB)
+	defer vermouth.SetNamedDB("orders", nil)
+	defer vermouth.SetNamedDB("report", nil)
+
+	var dao OrderDao
+	assert.NoError(t, vermouth.NewMapper(&dao))
+
+	r := gin.New()
+	vermouth.RegisterControllers(r, &DataSourceController{
+		Order: func(ctx context.Context, tx *sql.Tx, db *sql.DB) {
+			assert.Same(t, tx, vermouth.NamedTx(ctx, "orders"))
+			assert.Nil(t, vermouth.Tx(ctx))
+			_, err := dao.Insert(ctx, "apple")
+			assert.NoError(t, err)
+			db.Exec("INSERT INTO report")
+		},
+		Report: func(tx *sql.Tx, db *sql.DB) bool {
+			return tx == nil && db == reportDB
+		},
+	})
+
+	req, _ := http.NewRequest("POST", "/datasource/order", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"BEGIN Serializable READ ONLY", "tx: INSERT INTO orders (name) VALUES (?)[apple]", "COMMIT"}, orders.Ops())
+	assert.Equal(t, []string{"INSERT INTO report"}, report.Ops())
+
+	req, _ = http.NewRequest("GET", "/datasource/report", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, "true", w.Body.String())
+
+	// 事务选项
+	orders.Reset()
+	err := vermouth.Transactional(context.Background(), func(ctx context.Context) error {
+		assert.NotNil(t, vermouth.NamedTx(ctx, "orders"))
+		assert.Nil(t, vermouth.CurrentTx())
+		return nil
+	}, vermouth.TxOptions{DB: "orders", Isolation: sql.LevelReadCommitted})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"BEGIN Read Committed", "COMMIT"}, orders.Ops())
+
+	err = vermouth.Transactional(context.Background(), func(ctx context.Context) error {
+		return nil
+	}, vermouth.TxOptions{DB: "missing"})
+	assert.Error(t, err)
+}
+
+func TestTransactionTag(t *testing.T) {
+	var bad struct {
+		Bad func() `method:"GET" path:"/datasource/bad" transaction:"db=orders,isolation=unknown,retry"`
+	}
+	bad.Bad = func() {}
+	err := vermouth.RegisterControllersE(gin.New(), &bad)
+	de, ok := err.(*vermouth.DefinitionError)
+	if assert.True(t, ok) {
+		assert.Len(t, de.Messages, 2)
+	}
+}
+
+func TestTransactionTagNonStrict(t *testing.T) {
+	var bad struct {
+		Bad func() `method:"GET" path:"/datasource/typo" transaction:"isolation=serialisable"`
+	}
+	bad.Bad = func() {}
+	// 写错的隔离级别不会静默地使用默认级别
+	defer func() {
+		de, ok := recover().(*vermouth.DefinitionError)
+		if assert.True(t, ok) {
+			assert.Equal(t, []string{`.Bad: unknown transaction isolation "serialisable"`}, de.Messages)
+		}
+	}()
+	vermouth.RegisterControllers(gin.New(), &bad)
+	t.Fatal("RegisterControllers should panic")
+}
@@ -0,0 +1,79 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
+)
+
+type Download struct {
+	Name string `json:"name"`
+}
+
+type EnvelopeController struct {
+	_        interface{}                 `path:"/envelope"`
+	User     func() interface{}          `method:"GET" path:"/user"`
+	Raw      func() interface{}          `method:"GET" path:"/raw" raw:"true"`
+	Download func() *Download            `method:"GET" path:"/download"`
+	File     func(c *gin.Context)        `method:"GET" path:"/file"`
+	Fail     func() (interface{}, error) `method:"GET" path:"/fail"`
+}
+
+func NewEnvelopeController() *EnvelopeController {
+	return &EnvelopeController{
+		User: func() interface{} {
+			return gin.H{"name": "test"}
+		},
+		Raw: func() interface{} {
+			return gin.H{"name": "test"}
+		},
+		Download: func() *Download {
+			return &Download{Name: "a.txt"}
+		},
+		File: func(c *gin.Context) {
+			c.String(http.StatusOK, "file content")
+		},
+		Fail: func() (interface{}, error) {
+			return nil, vermouth.NewRuntimeError(409, "conflict")
+		},
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	r := gin.New()
+	vermouth.RegisterControllers(r, NewEnvelopeController())
+	vermouth.SetResponseEnvelope(vermouth.NewResponseEnvelope().Skip(&Download{}))
+	defer vermouth.SetResponseEnvelope(nil)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("X-Trace-Id", "trace-1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/envelope/user")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"code":0,"message":"ok","data":{"name":"test"},"traceId":"trace-1"}`, w.Body.String())
+	assert.Equal(t, "trace-1", w.Header().Get("X-Trace-Id"))
+
+	w = serve("/envelope/raw")
+	assert.JSONEq(t, `{"name":"test"}`, w.Body.String())
+
+	w = serve("/envelope/download")
+	assert.JSONEq(t, `{"name":"a.txt"}`, w.Body.String())
+
+	// 控制器已经输出了响应，不再追加
+	w = serve("/envelope/file")
+	assert.Equal(t, "file content", w.Body.String())
+
+	// 错误使用相同的结构
+	w = serve("/envelope/fail")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"code":409,"message":"conflict","data":null,"traceId":"trace-1"}`, w.Body.String())
+}
@@ -0,0 +1,93 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
+)
+
+type ErrorController struct {
+	_        interface{}                       `path:"/error"`
+	Runtime  func() interface{}                `method:"GET" path:"/runtime"`
+	Validate func(p *ErrorParam) interface{}   `method:"POST" path:"/validate" params:"p"`
+	Unknown  func() interface{}                `method:"GET" path:"/unknown"`
+	Custom   func() (interface{}, error)       `method:"GET" path:"/custom"`
+	Success  func() (map[string]string, error) `method:"GET" path:"/success"`
+}
+
+type ErrorParam struct {
+	Name string `json:"name" binding:"required" message:"name必填"`
+}
+
+type NotFoundError struct {
+	Resource string
+}
+
+func (e *NotFoundError) Error() string {
+	return e.Resource + " not found"
+}
+
+func NewErrorController() *ErrorController {
+	return &ErrorController{
+		Runtime: func() interface{} {
+			panic(vermouth.NewRuntimeError(403, "forbidden"))
+		},
+		Validate: func(p *ErrorParam) interface{} {
+			return p
+		},
+		Unknown: func() interface{} {
+			panic("boom")
+		},
+		Custom: func() (interface{}, error) {
+			return nil, fmt.Errorf("load user: %w", &NotFoundError{Resource: "user"})
+		},
+		Success: func() (map[string]string, error) {
+			return map[string]string{"name": "ok"}, nil
+		},
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	r := gin.New()
+	vermouth.RegisterControllers(r, NewErrorController())
+	vermouth.RegisterErrorHandler((*NotFoundError)(nil), func(ctx *vermouth.Context, err error) {
+		ctx.JSON(http.StatusNotFound, gin.H{"code": 404, "message": err.(*NotFoundError).Resource})
+	})
+
+	serve := func(method, path, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		res := map[string]interface{}{}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w, res
+	}
+
+	w, res := serve("GET", "/error/runtime", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "forbidden", res["message"])
+
+	w, res = serve("POST", "/error/validate", `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []interface{}{"name必填"}, res["data"])
+
+	w, res = serve("GET", "/error/unknown", "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotEmpty(t, res["traceId"])
+
+	// 返回的error同样会被映射，且支持errors.As匹配被包装的错误
+	w, res = serve("GET", "/error/custom", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "user", res["message"])
+
+	w, _ = serve("GET", "/error/success", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"name":"ok"}`, w.Body.String())
+}
@@ -0,0 +1,237 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 用于测试的数据库驱动，记录执行过的语句，不依赖真实的数据库
+type fakeDriver struct{}
+
+type fakeDB struct {
+	mu   sync.Mutex
+	ops  []string
+	rows map[string]*fakeRows
+	// 下一次执行该操作时返回错误，例如 COMMIT、ROLLBACK
+	fail map[string]bool
+}
+
+var (
+	fakeDBs   = map[string]*fakeDB{}
+	fakeDBsMu sync.Mutex
+)
+
+func init() {
+	sql.Register("vermouth_fake", fakeDriver{})
+}
+
+// 每个测试使用独立的数据库，避免互相影响
+func newFakeDB(t *testing.T) (*sql.DB, *fakeDB) {
+	return newNamedFakeDB(t, "")
+}
+
+// 同一个测试需要多个数据库时，通过name区分
+func newNamedFakeDB(t *testing.T, name string) (*sql.DB, *fakeDB) {
+	fake := &fakeDB{rows: map[string]*fakeRows{}, fail: map[string]bool{}}
+	dsn := t.Name() + "/" + name
+	fakeDBsMu.Lock()
+	fakeDBs[dsn] = fake
+	fakeDBsMu.Unlock()
+	db, err := sql.Open("vermouth_fake", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 只使用一个连接，保证事务内外的语句顺序
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db, fake
+}
+
+// 设置以prefix开头的查询返回的结果
+func (f *fakeDB) SetRows(prefix string, columns []string, values ...[]driver.Value) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rows[prefix] = &fakeRows{columns: columns, values: values}
+}
+
+func (f *fakeDB) Ops() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.ops...)
+}
+
+func (f *fakeDB) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ops = nil
+}
+
+// 下一次COMMIT或ROLLBACK返回错误
+func (f *fakeDB) FailNext(op string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fail[op] = true
+}
+
+func (f *fakeDB) record(op string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ops = append(f.ops, op)
+}
+
+func (f *fakeDB) recordTx(op string) error {
+	f.record(op)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail[op] {
+		delete(f.fail, op)
+		return fakeTxError{op: strings.ToLower(op)}
+	}
+	return nil
+}
+
+// COMMIT或ROLLBACK失败时返回的错误
+type fakeTxError struct {
+	op string
+}
+
+func (e fakeTxError) Error() string {
+	return e.op + " failed"
+}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+	fake, ok := fakeDBs[name]
+	if !ok {
+		return nil, fmt.Errorf("fake db %s not found", name)
+	}
+	return &fakeConn{db: fake}, nil
+}
+
+type fakeConn struct {
+	db   *fakeDB
+	inTx bool
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	op := "BEGIN"
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+		op += " " + sql.IsolationLevel(opts.Isolation).String()
+	}
+	if opts.ReadOnly {
+		op += " READ ONLY"
+	}
+	c.db.record(op)
+	c.inTx = true
+	return &fakeTx{conn: c}, nil
+}
+
+type fakeTx struct {
+	conn *fakeConn
+}
+
+func (tx *fakeTx) Commit() error {
+	tx.conn.inTx = false
+	return tx.conn.db.recordTx("COMMIT")
+}
+
+func (tx *fakeTx) Rollback() error {
+	tx.conn.inTx = false
+	return tx.conn.db.recordTx("ROLLBACK")
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) record(args []driver.Value) {
+	op := s.query
+	if len(args) > 0 {
+		op += fmt.Sprint(args)
+	}
+	if s.conn.inTx {
+		op = "tx: " + op
+	}
+	s.conn.db.record(op)
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.record(args)
+	if strings.HasPrefix(s.query, "FAIL") {
+		return nil, fmt.Errorf("exec failed")
+	}
+	return fakeResult{}, nil
+}
+
+type fakeResult struct{}
+
+func (fakeResult) LastInsertId() (int64, error) {
+	return 42, nil
+}
+
+func (fakeResult) RowsAffected() (int64, error) {
+	return 1, nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.record(args)
+	s.conn.db.mu.Lock()
+	defer s.conn.db.mu.Unlock()
+	for prefix, rows := range s.conn.db.rows {
+		if strings.HasPrefix(s.query, prefix) {
+			return &fakeRows{columns: rows.columns, values: rows.values}, nil
+		}
+	}
+	return &fakeRows{}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+	pos     int
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.pos])
+	r.pos++
+	return nil
+}
@@ -0,0 +1,177 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupInheritsThreadLocals(t *testing.T) {
+	name := vermouth.NewThreadLocal[string]()
+	defer name.Close()
+	count := vermouth.NewThreadLocal[int]()
+	defer count.Close()
+	name.Set("alice")
+	count.Set(1)
+
+	g := name.Group()
+	var mu sync.Mutex
+	var results []string
+	for i := 0; i < 3; i++ {
+		g.Go(func() error {
+			n, _ := name.Get()
+			c, _ := count.Get()
+			// 子协程中修改不影响父协程
+			name.Set("bob")
+			mu.Lock()
+			defer mu.Unlock()
+			if c == 1 {
+				results = append(results, n)
+			}
+			return nil
+		})
+	}
+	assert.NoError(t, g.Wait())
+	assert.Equal(t, []string{"alice", "alice", "alice"}, results)
+	n, _ := name.Get()
+	assert.Equal(t, "alice", n)
+}
+
+func TestGroupError(t *testing.T) {
+	first := errors.New("first")
+	g, ctx := vermouth.GroupWithContext(context.Background())
+	g.Go(func() error {
+		return first
+	})
+	g.Go(func() error {
+		// 其他子协程返回错误后取消
+		<-ctx.Done()
+		return errors.New("canceled")
+	})
+	assert.Equal(t, first, g.Wait())
+	assert.Error(t, ctx.Err())
+}
+
+func TestGroupPanic(t *testing.T) {
+	g := vermouth.NewGroup()
+	g.Go(func() error {
+		panic("boom")
+	})
+	g.Go(func() error {
+		return nil
+	})
+	defer func() {
+		e := recover()
+		if assert.IsType(t, &vermouth.PanicError{}, e) {
+			pe := e.(*vermouth.PanicError)
+			assert.Equal(t, "boom", pe.Value)
+			assert.Contains(t, string(pe.Stack), "TestGroupPanic")
+			assert.Equal(t, "panic in goroutine: boom", pe.Error())
+		}
+	}()
+	g.Wait()
+	t.Fatal("Wait should panic")
+}
+
+func TestGroupLimit(t *testing.T) {
+	g := vermouth.NewGroup()
+	g.SetLimit(2)
+	var running, max int32
+	release := make(chan struct{})
+	task := func() error {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			old := atomic.LoadInt32(&max)
+			if current <= old || atomic.CompareAndSwapInt32(&max, old, current) {
+				break
+			}
+		}
+		<-release
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil
+	}
+	g.Go(task)
+	g.Go(task)
+	waitFor(t, func() bool { return atomic.LoadInt32(&running) == 2 })
+	// 达到并发限制
+	assert.False(t, g.TryGo(task))
+	close(release)
+	for i := 0; i < 4; i++ {
+		g.Go(task)
+	}
+	assert.NoError(t, g.Wait())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&max))
+	assert.True(t, g.TryGo(task))
+	assert.NoError(t, g.Wait())
+}
+
+func TestThreadLocalGoPanic(t *testing.T) {
+	tl := vermouth.NewThreadLocal[string]()
+	defer tl.Close()
+	done := make(chan struct{})
+	tl.Go(func() {
+		defer close(done)
+		panic("boom")
+	})
+	<-done
+	// 进程没有退出，之后的协程正常执行
+	result := make(chan string)
+	tl.Set("ok")
+	tl.Go(func() {
+		result <- get(tl)
+	})
+	assert.Equal(t, "ok", <-result)
+}
+
+type GroupController struct {
+	_     interface{}                          `path:"/group"`
+	Paths func() ([]string, error)             `method:"GET" path:"/paths"`
+	Panic func(c *gin.Context) (string, error) `method:"GET" path:"/panic"`
+}
+
+func TestGroupInController(t *testing.T) {
+	r := gin.New()
+	vermouth.RegisterControllers(r, &GroupController{
+		Paths: func() ([]string, error) {
+			g := vermouth.NewGroup()
+			paths := make([]string, 2)
+			for i := range paths {
+				i := i
+				g.Go(func() error {
+					// 子协程中可以获取当前请求的上下文
+					paths[i] = vermouth.CurrentContext().ControllerInformation.Path
+					return nil
+				})
+			}
+			return paths, g.Wait()
+		},
+		Panic: func(c *gin.Context) (string, error) {
+			g := vermouth.NewGroup()
+			g.Go(func() error {
+				panic(vermouth.NewRuntimeError(http.StatusConflict, "conflict"))
+			})
+			return "", g.Wait()
+		},
+	})
+
+	req, _ := http.NewRequest("GET", "/group/paths", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, `["/group/paths","/group/paths"]`, w.Body.String())
+
+	// 子协程的panic交给错误处理器，按原来的错误类型返回
+	req, _ = http.NewRequest("GET", "/group/panic", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
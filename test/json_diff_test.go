@@ -0,0 +1,98 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffJSON(t *testing.T) {
+	original := []byte(`{"code":0,"data":{"id":1,"price":1.0,"createdAt":"2024-01-01","items":[1,2,3],"tags":["a","b"]},"traceId":"x"}`)
+	duplicate := []byte(`{"traceId":"y","data":{"tags":["b","a"],"items":[3,2,1],"createdAt":"2024-01-02","price":1.00001,"id":1},"code":0}`)
+
+	diffs := vermouth.DiffJSON(original, duplicate, vermouth.JSONDiffOptions{
+		Ignore:    []string{"traceId", "data.createdAt"},
+		Tolerance: 0.001,
+		Unordered: []string{"data.items", "data.tags"},
+	})
+	assert.Empty(t, diffs)
+
+	diffs = vermouth.DiffJSON(original, duplicate, vermouth.JSONDiffOptions{Ignore: []string{"traceId", "data.createdAt", "data.tags"}})
+	assert.Equal(t, []vermouth.JSONDiff{
+		{Path: "data.items.0", Kind: "changed", Original: json.Number("1"), Duplicate: json.Number("3")},
+		{Path: "data.items.2", Kind: "changed", Original: json.Number("3"), Duplicate: json.Number("1")},
+		{Path: "data.price", Kind: "changed", Original: json.Number("1.0"), Duplicate: json.Number("1.00001")},
+	}, diffs)
+
+	diffs = vermouth.DiffJSON([]byte(`{"list":[{"id":1},{"id":2}]}`), []byte(`{"list":[{"id":2,"name":"b"}],"extra":true}`), vermouth.JSONDiffOptions{
+		Ignore:    []string{"list.*.name"},
+		Unordered: []string{"*"},
+	})
+	assert.Equal(t, []vermouth.JSONDiff{
+		{Path: "extra", Kind: "added", Duplicate: true},
+		{Path: "list.0", Kind: "removed", Original: map[string]interface{}{"id": json.Number("1")}},
+	}, diffs)
+
+	// 超过2^53的整数精确比较，不受误差影响
+	diffs = vermouth.DiffJSON([]byte(`{"id":9007199254740993,"price":1.0}`), []byte(`{"id":9007199254740992,"price":1.00001}`), vermouth.JSONDiffOptions{Tolerance: 0.001})
+	assert.Equal(t, []vermouth.JSONDiff{
+		{Path: "id", Kind: "changed", Original: json.Number("9007199254740993"), Duplicate: json.Number("9007199254740992")},
+	}, diffs)
+	assert.Len(t, vermouth.DiffJSON([]byte(`{"id":1}`), []byte(`{"id":2}`), vermouth.JSONDiffOptions{Tolerance: 1}), 1)
+	assert.Empty(t, vermouth.DiffJSON([]byte(`{"id":1}`), []byte(`{"id":1.0}`), vermouth.JSONDiffOptions{}))
+
+	// 不是json时按字节比较
+	assert.Empty(t, vermouth.DiffJSON([]byte("ok"), []byte("ok"), vermouth.JSONDiffOptions{}))
+	assert.Len(t, vermouth.DiffJSON([]byte("ok"), []byte("fail"), vermouth.JSONDiffOptions{}), 1)
+}
+
+type CoverDiffController struct {
+	_     interface{}                   `path:"/cover-diff"`
+	Same  func() map[string]interface{} `method:"GET" path:"/new/same" cover_url:"/cover-diff/old/same" cover_ignore:"time"`
+	Other func() map[string]interface{} `method:"GET" path:"/new/other" cover_url:"/cover-diff/old/other" cover_ignore:"time"`
+}
+
+func TestCoverUrlDiff(t *testing.T) {
+	sink := vermouth.NewMemorySink(10)
+	cu := vermouth.NewCoverUrl(vermouth.CoverUrlConfig{Sinks: []vermouth.MismatchSink{sink}})
+	r := gin.New()
+	r.Use(cu.Middleware())
+	vermouth.RegisterControllers(r, &CoverDiffController{
+		Same: func() map[string]interface{} {
+			return map[string]interface{}{"id": 1, "time": time.Now().UnixNano()}
+		},
+		Other: func() map[string]interface{} {
+			return map[string]interface{}{"id": 2}
+		},
+	})
+	old := func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"time": time.Now().UnixNano(), "id": 1})
+	}
+	r.GET("/cover-diff/old/same", old)
+	r.GET("/cover-diff/old/other", old)
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+	for _, path := range []string{"/cover-diff/old/same", "/cover-diff/old/other"} {
+		resp, err := http.Get(server.URL + path)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+	}
+	// Close等待队列中的请求比较完毕
+	cu.Close()
+
+	// 只有id不同的接口会记录
+	events := sink.Events()
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "/cover-diff/old/other", events[0].Route)
+		assert.Equal(t, []vermouth.JSONDiff{{Path: "id", Kind: "changed", Original: json.Number("1"), Duplicate: json.Number("2")}}, events[0].Diff)
+	}
+	assert.Equal(t, int64(1), cu.Stats().Matched)
+}
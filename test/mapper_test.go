@@ -0,0 +1,196 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
+)
+
+type UserRow struct {
+	Id       int64 `db:"id"`
+	UserName string
+	Age      int
+}
+
+type UserDao struct {
+	FindByName func(ctx context.Context, name string) ([]UserRow, error) `sql:"SELECT id, user_name, age FROM user WHERE name = #{name}"`
+	FindOne    func(id int64) (*UserRow, error)                          `sql:"SELECT id, user_name, age FROM user WHERE id = #{id}"`
+	FindMap    func(id int64) (map[string]interface{}, error)            `sql:"SELECT id, user_name, age FROM user WHERE id = #{id}"`
+	Count      func() (int, error)                                       `sql:"SELECT COUNT(*) FROM user"`
+	Insert     func(ctx context.Context, user *UserRow) (int64, error)   `sql:"INSERT INTO user (user_name, age) VALUES (#{UserName}, #{Age})" result:"insert_id"`
+	Update     func(age int, name string) (int64, error)                 `sql:"UPDATE user SET age = #{age} WHERE name = #{name}" params:"age,name"`
+	Delete     func(tx *sql.Tx, id int64) error                          `sql:"DELETE FROM user WHERE id = #{id}"`
+}
+
+type MapperController struct {
+	_   interface{}                              `path:"/mapper"`
+	Add func(ctx context.Context) (int64, error) `method:"POST" path:"/add" transaction:"true"`
+}
+
+func TestMapper(t *testing.T) {
+	db, fake := newFakeDB(t)
+	old := vermouth.GetDB()
+	vermouth.SetDB(db)
+	defer vermouth.SetDB(old)
+
+	var dao UserDao
+	assert.NoError(t, vermouth.NewMapper(&dao))
+
+	fake.SetRows("SELECT id", []string{"id", "user_name", "age"}, []driver.Value{int64(1), "test", int64(18)}, []driver.Value{int64(2), "test", int64(20)})
+	fake.SetRows("SELECT COUNT", []string{"count"}, []driver.Value{int64(2)})
+
+	users, err := dao.FindByName(context.Background(), "test")
+	assert.NoError(t, err)
+	assert.Equal(t, []UserRow{{Id: 1, UserName: "test", Age: 18}, {Id: 2, UserName: "test", Age: 20}}, users)
+
+	user, err := dao.FindOne(1)
+	assert.NoError(t, err)
+	assert.Equal(t, &UserRow{Id: 1, UserName: "test", Age: 18}, user)
+
+	row, err := dao.FindMap(1)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": int64(1), "user_name": "test", "age": int64(18)}, row)
+
+	count, err := dao.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	id, err := dao.Insert(context.Background(), &UserRow{UserName: "new", Age: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), id)
+
+	affected, err := dao.Update(30, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), affected)
+
+	assert.Equal(t, []string{
+		"SELECT id, user_name, age FROM user WHERE name = ?[test]",
+		"SELECT id, user_name, age FROM user WHERE id = ?[1]",
+		"SELECT id, user_name, age FROM user WHERE id = ?[1]",
+		"SELECT COUNT(*) FROM user",
+		"INSERT INTO user (user_name, age) VALUES (?, ?)[new 1]",
+		"UPDATE user SET age = ? WHERE name = ?[30 test]",
+	}, fake.Ops())
+
+	// 没有数据时，指针返回nil
+	fake.SetRows("SELECT id", []string{"id", "user_name", "age"})
+	user, err = dao.FindOne(3)
+	assert.NoError(t, err)
+	assert.Nil(t, user)
+	_, err = dao.FindMap(3)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// 自动加入当前请求的事务
+	fake.Reset()
+	r := gin.New()
+	vermouth.RegisterControllers(r, &MapperController{
+		Add: func(ctx context.Context) (int64, error) {
+			return dao.Insert(ctx, &UserRow{UserName: "tx", Age: 2})
+		},
+	})
+	req, _ := http.NewRequest("POST", "/mapper/add", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, "42", w.Body.String())
+	assert.Equal(t, []string{"BEGIN", "tx: INSERT INTO user (user_name, age) VALUES (?, ?)[tx 2]", "COMMIT"}, fake.Ops())
+}
+
+func TestMapperDefinition(t *testing.T) {
+	var dao struct {
+		NoError func(id int) int                    `sql:"SELECT id FROM user WHERE id = #{id}"`
+		Missing func() error                        `sql:"DELETE FROM user WHERE id = #{id}"`
+		Exec    func(id int) ([]UserRow, error)     `sql:"DELETE FROM user WHERE id = #{id}"`
+		Params  func(a, b int) error                `sql:"DELETE FROM user WHERE id = #{a}" params:"a"`
+		Map     func() ([]map[string]string, error) `sql:"SELECT id FROM user"`
+	}
+	err := vermouth.NewMapper(&dao)
+	de, ok := err.(*vermouth.DefinitionError)
+	if assert.True(t, ok) {
+		assert.Len(t, de.Messages, 5)
+		assert.Contains(t, de.Messages, ".Map: query result map must be map[string]interface{}, got map[string]string")
+	}
+	// 可以使用map[string]interface{}
+	var rows struct {
+		Map func() (map[string]interface{}, error) `sql:"SELECT id FROM user"`
+	}
+	assert.NoError(t, vermouth.NewMapper(&rows))
+}
+
+type auditRow struct {
+	CreatedBy string `db:"created_by"`
+}
+
+type embeddedRow struct {
+	UserRow
+	*auditRow
+}
+
+type EmbeddedDao struct {
+	Find func(id int64) (*embeddedRow, error) `sql:"SELECT id, user_name, created_by FROM user WHERE id = #{id}"`
+	Save func(row *embeddedRow) error         `sql:"UPDATE user SET created_by = #{created_by} WHERE id = #{id}"`
+}
+
+func TestMapperEmbedded(t *testing.T) {
+	db, fake := newFakeDB(t)
+	old := vermouth.GetDB()
+	vermouth.SetDB(db)
+	defer vermouth.SetDB(old)
+
+	var dao EmbeddedDao
+	assert.NoError(t, vermouth.NewMapper(&dao))
+
+	// 扫描到嵌入结构体的字段，嵌入的结构体指针自动创建
+	fake.SetRows("SELECT id", []string{"id", "user_name", "created_by"}, []driver.Value{int64(1), "test", "admin"})
+	row, err := dao.Find(1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), row.Id)
+	assert.Equal(t, "test", row.UserName)
+	assert.Equal(t, "admin", row.CreatedBy)
+
+	assert.NoError(t, dao.Save(row))
+	// 嵌入的结构体指针为nil时参数为nil
+	assert.NoError(t, dao.Save(&embeddedRow{UserRow: UserRow{Id: 2}}))
+	assert.Equal(t, []string{
+		"SELECT id, user_name, created_by FROM user WHERE id = ?[1]",
+		"UPDATE user SET created_by = ? WHERE id = ?[admin 1]",
+		"UPDATE user SET created_by = ? WHERE id = ?[<nil> 2]",
+	}, fake.Ops())
+}
+
+type MultiLineDao struct {
+	FindAll   func() ([]UserRow, error) `sql:"SELECT\n\tid, user_name, age\nFROM user"`
+	FindTab   func() ([]UserRow, error) `sql:"SELECT\tid, user_name, age FROM user"`
+	FindUnion func() ([]UserRow, error) `sql:"(SELECT id, user_name, age FROM user) UNION (SELECT id, user_name, age FROM user)"`
+}
+
+func TestMapperMultiLineSelect(t *testing.T) {
+	db, fake := newFakeDB(t)
+	old := vermouth.GetDB()
+	vermouth.SetDB(db)
+	defer vermouth.SetDB(old)
+
+	var dao MultiLineDao
+	assert.NoError(t, vermouth.NewMapper(&dao))
+	columns := []string{"id", "user_name", "age"}
+	expected := []UserRow{{Id: 1, UserName: "test", Age: 18}}
+	fake.SetRows("SELECT", columns, []driver.Value{int64(1), "test", int64(18)})
+	fake.SetRows("(SELECT", columns, []driver.Value{int64(1), "test", int64(18)})
+
+	// 关键字之后是换行、制表符，或者以括号开头的语句同样按查询处理
+	users, err := dao.FindAll()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, users)
+	users, err = dao.FindTab()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, users)
+	users, err = dao.FindUnion()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, users)
+}
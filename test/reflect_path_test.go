@@ -0,0 +1,215 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
+)
+
+type pathItem struct {
+	Sku   string `binding:"required" message:"SKU不能为空"`
+	Count int
+}
+
+type pathOrder struct {
+	ID     int
+	Items  []pathItem `binding:"dive"`
+	Buyer  *User
+	Meta   map[string]string
+	Extra  map[string]*pathItem
+	Tags   [2]string
+	Any    interface{}
+	hidden string
+}
+
+func newPathOrder() *pathOrder {
+	return &pathOrder{
+		ID:    1,
+		Items: []pathItem{{Sku: "a", Count: 1}, {Sku: "b", Count: 2}, {Sku: "c", Count: 3}},
+		Meta:  map[string]string{"a.b": "dot", "source": "web"},
+		Tags:  [2]string{"x", "y"},
+		Any:   &User{Name: "any"},
+	}
+}
+
+func TestGetPath(t *testing.T) {
+	order := newPathOrder()
+	for path, expected := range map[string]interface{}{
+		"ID":             1,
+		"Items[2].Sku":   "c",
+		"Items[1]":       pathItem{Sku: "b", Count: 2},
+		"Meta[source]":   "web",
+		"Meta.source":    "web",
+		`Meta["a.b"]`:    "dot",
+		"Tags[1]":        "y",
+		"Any.Name":       "any",
+		"Items[0].Count": 1,
+	} {
+		value, err := vermouth.GetPath(order, path)
+		assert.NoError(t, err, path)
+		assert.Equal(t, expected, value, path)
+	}
+	// 不是指针也可以读取
+	value, err := vermouth.GetPath(*order, "Items[0].Sku")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", value)
+
+	for path, message := range map[string]string{
+		"Items[3].Sku": "路径Items[3].Sku: Items的下标3超出范围，长度为3",
+		"Items[x]":     "路径Items[x]: Items的下标x不是整数",
+		"Items.Sku":    "路径Items.Sku: Items是[]test.pathItem，需要使用下标访问，不能使用.Sku",
+		"Buyer.Name":   "路径Buyer.Name: Buyer为nil",
+		"Name":         "路径Name: 根对象的类型test.pathOrder中不存在字段Name",
+		"Meta[none]":   "路径Meta[none]: Meta中不存在键none",
+		"ID.Value":     "路径ID.Value: ID的类型为int，不能访问Value",
+		"hidden":       "路径hidden: 根对象的字段hidden未导出",
+		"Items[0":      "路径Items[0缺少]",
+		"Items..Sku":   "路径Items..Sku中存在空的字段名",
+		"Items[0]Sku":  "路径Items[0]Sku中]之后必须是.或者[",
+		"":             "路径为空",
+	} {
+		_, err := vermouth.GetPath(order, path)
+		assert.EqualError(t, err, message, path)
+	}
+}
+
+func TestSetPath(t *testing.T) {
+	order := &pathOrder{Items: make([]pathItem, 2)}
+	assert.NoError(t, vermouth.SetPath(order, "Items[1].Sku", "b"))
+	assert.Equal(t, "b", order.Items[1].Sku)
+	// 自动创建指针和map
+	assert.NoError(t, vermouth.SetPath(order, "Buyer.Name", "alice"))
+	assert.Equal(t, "alice", order.Buyer.Name)
+	assert.NoError(t, vermouth.SetPath(order, "Meta[source]", "app"))
+	assert.Equal(t, "app", order.Meta["source"])
+	assert.NoError(t, vermouth.SetPath(order, "Extra[gift].Count", 2))
+	assert.Equal(t, 2, order.Extra["gift"].Count)
+	assert.NoError(t, vermouth.SetPath(order, "Tags[0]", "x"))
+	assert.Equal(t, "x", order.Tags[0])
+	// interface中的值
+	order.Any = User{Name: "any"}
+	assert.NoError(t, vermouth.SetPath(order, "Any.Age", 18))
+	assert.Equal(t, User{Name: "any", Age: 18}, order.Any)
+	// 类型检查同FieldInfo.Set
+	count := 3
+	assert.NoError(t, vermouth.SetPath(order, "Items[0].Count", &count))
+	assert.Equal(t, 3, order.Items[0].Count)
+	assert.EqualError(t, vermouth.SetPath(order, "Items[0].Count", "3"),
+		"路径Items[0].Count: 字段Items[0].Count类型为int，不能设置为string")
+	assert.EqualError(t, vermouth.SetPath(order, "Items[2].Sku", "c"),
+		"路径Items[2].Sku: Items的下标2超出范围，长度为2")
+	assert.Error(t, vermouth.SetPath(*order, "ID", 1))
+}
+
+func TestWalk(t *testing.T) {
+	order := &pathOrder{
+		ID:    1,
+		Items: []pathItem{{Sku: "a"}},
+		Meta:  map[string]string{"b": "2", "a": "1"},
+	}
+	var paths []string
+	err := vermouth.Walk(order, func(path string, value interface{}) error {
+		paths = append(paths, path)
+		// Tags不访问内部的元素
+		if path == "Tags" {
+			return vermouth.SkipPath
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"ID", "Items", "Items[0]", "Items[0].Sku", "Items[0].Count",
+		"Buyer", "Meta", "Meta[a]", "Meta[b]", "Extra", "Tags", "Any",
+	}, paths)
+	// 每个路径都可以使用GetPath读取
+	vermouth.Walk(order, func(path string, value interface{}) error {
+		got, err := vermouth.GetPath(order, path)
+		assert.NoError(t, err, path)
+		assert.Equal(t, value, got, path)
+		return nil
+	})
+
+	stop := errors.New("stop")
+	count := 0
+	assert.Equal(t, stop, vermouth.Walk(order, func(path string, value interface{}) error {
+		count++
+		if path == "Items[0]" {
+			return stop
+		}
+		return nil
+	}))
+	assert.Equal(t, 3, count)
+}
+
+func TestWalkQuotedKey(t *testing.T) {
+	order := &pathOrder{Meta: map[string]string{"a.b": "1", "x]y": "2", `q"`: "3", "": "4", "plain": "5"}}
+	var paths []string
+	assert.NoError(t, vermouth.Walk(order.Meta, func(path string, value interface{}) error {
+		paths = append(paths, path)
+		// 包含特殊字符的键加上引号，仍然可以使用GetPath读取
+		got, err := vermouth.GetPath(order.Meta, path)
+		assert.NoError(t, err, path)
+		assert.Equal(t, value, got, path)
+		return nil
+	}))
+	assert.Equal(t, []string{`[""]`, `["a.b"]`, "[plain]", `["q\""]`, `["x]y"]`}, paths)
+
+	value, err := vermouth.GetPath(order, `Meta['x]y']`)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", value)
+	for path, message := range map[string]string{
+		`Meta["a.b`:   `路径Meta["a.b中的引号不完整`,
+		`Meta["a.b"x`: `路径Meta["a.b"x缺少]`,
+		`Meta['a.b`:   `路径Meta['a.b中的引号不完整`,
+	} {
+		_, err := vermouth.GetPath(order, path)
+		assert.EqualError(t, err, message, path)
+	}
+}
+
+func TestWalkEmbedded(t *testing.T) {
+	obj := &reflectEmbedded{reflectBase: reflectBase{ID: 1}, Name: "a"}
+	var paths []string
+	walk := func(path string, value interface{}) error {
+		paths = append(paths, path)
+		// 未导出的嵌入结构体中的字段作为提升的字段访问，与GetPath一致
+		got, err := vermouth.GetPath(obj, path)
+		assert.NoError(t, err, path)
+		assert.Equal(t, value, got, path)
+		return nil
+	}
+	assert.NoError(t, vermouth.Walk(obj, walk))
+	// 嵌入的结构体指针为nil时不访问其中的字段
+	assert.Equal(t, []string{"ID", "Name", "Email"}, paths)
+
+	obj.reflectAudit = &reflectAudit{CreatedBy: "admin"}
+	paths = nil
+	assert.NoError(t, vermouth.Walk(obj, walk))
+	assert.Equal(t, []string{"ID", "CreatedBy", "Name", "Email"}, paths)
+}
+
+type PathValidateController struct {
+	_      interface{}                    `path:"/path-validate"`
+	Create func(o *pathOrder) interface{} `method:"POST" path:"/create" params:"o=json"`
+}
+
+func TestValidateNestedNamespace(t *testing.T) {
+	r := gin.New()
+	vermouth.RegisterControllers(r, &PathValidateController{
+		Create: func(o *pathOrder) interface{} {
+			return o.ID
+		},
+	})
+	req, _ := http.NewRequest("POST", "/path-validate/create", bytes.NewBufferString(`{"Items":[{"Sku":"a"},{"Count":1}]}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	// 切片元素中字段的校验信息
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "SKU不能为空")
+}
@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
+	"unsafe"
 
 	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
 )
 
 type User struct {
@@ -83,3 +86,257 @@ func TestReflect(t *testing.T) {
 		fmt.Printf("性能比例: %.2f%%\n", float64(reflectResult.N)/float64(directResult.N)*100)
 	})
 }
+
+type reflectStatus int
+
+type reflectKinds struct {
+	Bool    bool
+	Int     int
+	Int8    int8
+	Int16   int16
+	Int32   int32
+	Int64   int64
+	Uint    uint
+	Uint8   uint8
+	Uint16  uint16
+	Uint32  uint32
+	Uint64  uint64
+	Float32 float32
+	Float64 float64
+	String  string
+	Bytes   []byte
+	Time    time.Time
+	Ptr     *User
+	Slice   []string
+	Map     map[string]int
+	Status  reflectStatus
+	Any     interface{}
+	User    User
+}
+
+func reflectKindValues() map[string]interface{} {
+	return map[string]interface{}{
+		"Bool":    true,
+		"Int":     -1,
+		"Int8":    int8(-8),
+		"Int16":   int16(-16),
+		"Int32":   int32(-32),
+		"Int64":   int64(-64),
+		"Uint":    uint(1),
+		"Uint8":   uint8(8),
+		"Uint16":  uint16(16),
+		"Uint32":  uint32(32),
+		"Uint64":  uint64(64),
+		"Float32": float32(3.2),
+		"Float64": 6.4,
+		"String":  "test",
+		"Bytes":   []byte("bytes"),
+		"Time":    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		"Ptr":     &User{Name: "ptr"},
+		"Slice":   []string{"a", "b"},
+		"Map":     map[string]int{"a": 1},
+		"Status":  reflectStatus(2),
+		"Any":     "any",
+		"User":    User{Name: "user", Age: 1},
+	}
+}
+
+func TestFieldInfoKinds(t *testing.T) {
+	obj := &reflectKinds{}
+	info := vermouth.GetTypeInfo(reflect.TypeOf(obj))
+	for name, value := range reflectKindValues() {
+		field := info.Fields[name]
+		assert.NoError(t, field.Set(obj, value), name)
+		got, err := field.Get(obj)
+		assert.NoError(t, err, name)
+		assert.Equal(t, value, got, name)
+		// 读取的值保留字段的类型
+		if field.Type.Kind() != reflect.Interface {
+			assert.Equal(t, field.Type, reflect.TypeOf(got), name)
+		}
+	}
+	assert.Equal(t, reflectStatus(2), obj.Status)
+	assert.Equal(t, "ptr", obj.Ptr.Name)
+	assert.Equal(t, []byte("bytes"), obj.Bytes)
+}
+
+func TestFieldInfoSetCheck(t *testing.T) {
+	obj := &reflectKinds{Ptr: &User{}, Slice: []string{"a"}}
+
+	// 字段类型的指针
+	age := 18
+	assert.NoError(t, vermouth.SetField(obj, "Int", &age))
+	assert.Equal(t, 18, obj.Int)
+	// 底层类型相同的自定义类型
+	assert.NoError(t, vermouth.SetField(obj, "Status", 3))
+	assert.Equal(t, reflectStatus(3), obj.Status)
+	assert.NoError(t, vermouth.SetField(obj, "Int", reflectStatus(4)))
+	assert.Equal(t, 4, obj.Int)
+	// nil
+	assert.NoError(t, vermouth.SetField(obj, "Ptr", nil))
+	assert.Nil(t, obj.Ptr)
+	assert.NoError(t, vermouth.SetField(obj, "Slice", nil))
+	assert.Nil(t, obj.Slice)
+	assert.Error(t, vermouth.SetField(obj, "Int", nil))
+
+	// 类型不匹配时返回错误，不修改字段
+	assert.EqualError(t, vermouth.SetField(obj, "Int8", 1), "字段Int8类型为int8，不能设置为int")
+	assert.Error(t, vermouth.SetField(obj, "String", 1))
+	assert.Error(t, vermouth.SetField(obj, "Bytes", "bytes"))
+	assert.Error(t, vermouth.SetField(obj, "Map", map[string]string{}))
+	assert.Equal(t, int8(0), obj.Int8)
+	assert.Equal(t, "", obj.String)
+
+	// obj必须是结构体指针
+	field := vermouth.GetTypeInfo(reflect.TypeOf(obj)).Fields["Int"]
+	assert.Error(t, field.Set(*obj, 1))
+	assert.Error(t, field.Set((*reflectKinds)(nil), 1))
+	_, err := field.Get(*obj)
+	assert.Error(t, err)
+
+	assert.NoError(t, vermouth.SetFieldByPtr(unsafe.Pointer(&obj.Int64), int64(5)))
+	assert.Equal(t, int64(5), obj.Int64)
+	// 为nil的指针写入零值，同FieldInfo.Set
+	assert.NoError(t, vermouth.SetFieldByPtr(unsafe.Pointer(&obj.Int64), (*int64)(nil)))
+	assert.Equal(t, int64(0), obj.Int64)
+	// 其他类型使用反射写入
+	assert.NoError(t, vermouth.SetFieldByPtr(unsafe.Pointer(&obj.User), User{Name: "a"}))
+	assert.Equal(t, User{Name: "a"}, obj.User)
+	assert.NoError(t, vermouth.SetFieldByPtr(unsafe.Pointer(&obj.Ptr), &User{Name: "b"}))
+	assert.Equal(t, &User{Name: "b"}, obj.Ptr)
+	assert.NoError(t, vermouth.SetFieldByPtr(unsafe.Pointer(&obj.Slice), []string{"c"}))
+	assert.Equal(t, []string{"c"}, obj.Slice)
+	assert.NoError(t, vermouth.SetFieldByPtr(unsafe.Pointer(&obj.Map), map[string]int{"d": 1}))
+	assert.Equal(t, map[string]int{"d": 1}, obj.Map)
+	assert.Error(t, vermouth.SetFieldByPtr(unsafe.Pointer(&obj.Map), nil))
+}
+
+func BenchmarkFieldInfoSet(b *testing.B) {
+	obj := &reflectKinds{}
+	info := vermouth.GetTypeInfo(reflect.TypeOf(obj))
+	values := reflectKindValues()
+	for _, name := range reflectKindNames {
+		field, value := info.Fields[name], values[name]
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				field.Set(obj, value)
+			}
+		})
+	}
+}
+
+func BenchmarkFieldInfoGet(b *testing.B) {
+	obj := &reflectKinds{}
+	info := vermouth.GetTypeInfo(reflect.TypeOf(obj))
+	values := reflectKindValues()
+	for _, name := range reflectKindNames {
+		field := info.Fields[name]
+		field.Set(obj, values[name])
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				field.Get(obj)
+			}
+		})
+	}
+}
+
+var reflectKindNames = []string{
+	"Bool", "Int", "Int8", "Int16", "Int32", "Int64",
+	"Uint", "Uint8", "Uint16", "Uint32", "Uint64", "Float32", "Float64",
+	"String", "Bytes", "Time", "Ptr", "Slice", "Map", "Status", "Any", "User",
+}
+
+type reflectBase struct {
+	ID      int64  `json:"id" form:"id"`
+	Name    string `json:"base_name"`
+	Version int
+}
+
+type reflectAudit struct {
+	CreatedBy string `json:"created_by" db:"created_by"`
+	Version   int
+}
+
+type reflectEmbedded struct {
+	reflectBase
+	*reflectAudit
+	Name  string `json:"user_name,omitempty" form:"name" binding:"required,max=10" message:"required=名称不能为空"`
+	Email string `json:"-"`
+}
+
+func TestTypeInfoEmbedded(t *testing.T) {
+	info := vermouth.GetTypeInfo(reflect.TypeOf(&reflectEmbedded{}))
+	// 提升的字段
+	assert.Contains(t, info.Fields, "ID")
+	assert.Contains(t, info.Fields, "CreatedBy")
+	assert.Contains(t, info.Fields, "reflectBase")
+	// 外层的字段优先，同一层级冲突的字段不提升
+	assert.Equal(t, []int{2}, info.Fields["Name"].Index)
+	assert.NotContains(t, info.Fields, "Version")
+
+	obj := &reflectEmbedded{reflectBase: reflectBase{ID: 1}}
+	id, err := vermouth.GetField(obj, "ID")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), id)
+	assert.NoError(t, vermouth.SetField(obj, "ID", int64(2)))
+	assert.Equal(t, int64(2), obj.ID)
+
+	// 嵌入的结构体指针为nil时，读取返回错误，设置时自动创建
+	_, err = vermouth.GetField(obj, "CreatedBy")
+	assert.EqualError(t, err, "嵌入的字段reflectAudit为nil")
+	assert.True(t, info.Fields["CreatedBy"].GetPointer(obj) == nil)
+	_, err = vermouth.GetPath(obj, "CreatedBy")
+	assert.Error(t, err)
+	// 读取不会创建嵌入的结构体
+	assert.Nil(t, obj.reflectAudit)
+	assert.NoError(t, vermouth.SetField(obj, "CreatedBy", "admin"))
+	assert.Equal(t, "admin", obj.CreatedBy)
+	createdBy, err := vermouth.GetField(obj, "CreatedBy")
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", createdBy)
+
+	// 路径中同样可以使用提升的字段
+	assert.NoError(t, vermouth.SetPath(obj, "ID", int64(3)))
+	value, err := vermouth.GetPath(obj, "ID")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), value)
+	// 未导出的嵌入结构体不能直接访问
+	assert.Error(t, vermouth.SetPath(obj, "reflectBase.Version", 3))
+	value, err = vermouth.GetPath(*obj, "CreatedBy")
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", value)
+	_, err = vermouth.GetPath(reflectEmbedded{}, "CreatedBy")
+	assert.Error(t, err)
+}
+
+func TestTypeInfoTags(t *testing.T) {
+	info := vermouth.GetTypeInfo(reflect.TypeOf(reflectEmbedded{}))
+	name := info.Fields["Name"]
+	assert.Equal(t, &vermouth.FieldTag{Value: "user_name,omitempty", Name: "user_name", Options: []string{"omitempty"}}, name.Tags["json"])
+	assert.Equal(t, "name", name.Tags["form"].Name)
+	assert.Equal(t, "required,max=10", name.Tags["binding"].Value)
+	assert.Equal(t, "required=名称不能为空", name.Tags["message"].Value)
+
+	field, ok := info.FieldByTag("json", "user_name")
+	assert.True(t, ok)
+	assert.Same(t, name, field)
+	field, ok = info.FieldByTag("form", "name")
+	assert.True(t, ok)
+	assert.Same(t, name, field)
+	// 提升的字段
+	field, ok = info.FieldByTag("json", "created_by")
+	assert.True(t, ok)
+	assert.Equal(t, "CreatedBy", field.Name)
+	field, ok = info.FieldByTag("json", "id")
+	assert.True(t, ok)
+	assert.Equal(t, []int{0, 0}, field.Index)
+	// 被外层字段覆盖的字段
+	_, ok = info.FieldByTag("json", "base_name")
+	assert.False(t, ok)
+	_, ok = info.FieldByTag("json", "-")
+	assert.False(t, ok)
+	_, ok = info.FieldByTag("json", "Email")
+	assert.False(t, ok)
+}
@@ -0,0 +1,66 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
+)
+
+var scopeUser = vermouth.NewThreadLocal[string]()
+
+// service层不需要传递上下文
+func scopeService() string {
+	ctx := vermouth.CurrentContext()
+	if ctx == nil {
+		return ""
+	}
+	user, _ := scopeUser.Get()
+	return ctx.ControllerInformation.Path + " " + user
+}
+
+type RequestScopeController struct {
+	_    interface{}                 `path:"/request-scope"`
+	Info func() string               `method:"GET" path:"/info"`
+	Set  func(name string) string    `method:"GET" path:"/set" params:"name"`
+	Peek func(c *gin.Context) string `method:"GET" path:"/peek"`
+}
+
+func TestRequestScope(t *testing.T) {
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		// 中间件中设置的值在控制器中可以读取
+		if user := c.GetHeader("X-User"); user != "" {
+			scopeUser.Set(user)
+		}
+	})
+	vermouth.RegisterControllers(r, &RequestScopeController{
+		Info: scopeService,
+		Set: func(name string) string {
+			scopeUser.Set(name)
+			return scopeService()
+		},
+		Peek: func(c *gin.Context) string {
+			user, _ := scopeUser.Get()
+			return user
+		},
+	})
+	get := func(path, user string) string {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	assert.Equal(t, `"/request-scope/info alice"`, get("/request-scope/info", "alice"))
+	assert.Equal(t, `"/request-scope/set bob"`, get("/request-scope/set?name=bob", ""))
+	// 同一个goroutine处理的下一个请求看不到之前的值
+	assert.Equal(t, `""`, get("/request-scope/peek", ""))
+	_, ok := scopeUser.Get()
+	assert.False(t, ok)
+	assert.Nil(t, vermouth.CurrentContext())
+}
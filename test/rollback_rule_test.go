@@ -0,0 +1,125 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
+)
+
+type RollbackController struct {
+	_           interface{}                       `path:"/rollback"`
+	Error       func() (interface{}, error)       `method:"POST" path:"/error" transaction:"true"`
+	NoRollback  func() (interface{}, error)       `method:"POST" path:"/no" transaction:"true" no_rollback_for:"NotFoundError"`
+	RollbackFor func() (interface{}, error)       `method:"POST" path:"/for" transaction:"true" rollback_for:"*vermouth.RuntimeError"`
+	When        func() map[string]interface{}     `method:"POST" path:"/when" transaction:"true" rollback_when:"code_failed"`
+	Failed      func() (interface{}, error)       `method:"POST" path:"/failed" transaction:"true"`
+	Panic       func() (map[string]string, error) `method:"POST" path:"/panic" transaction:"true" no_rollback_for:"NotFoundError"`
+}
+
+func TestRollbackRule(t *testing.T) {
+	db, fake := newFakeDB(t)
+	old := vermouth.GetDB()
+	vermouth.SetDB(db)
+	defer vermouth.SetDB(old)
+
+	vermouth.RegisterRollbackRule("code_failed", func(ctx *vermouth.Context) bool {
+		res, ok := ctx.Result[0].(map[string]interface{})
+		return ok && res["code"] != 0
+	})
+	notFound := func() (interface{}, error) {
+		return nil, fmt.Errorf("load user: %w", &NotFoundError{Resource: "user"})
+	}
+	r := gin.New()
+	vermouth.RegisterControllers(r, &RollbackController{
+		Error:       notFound,
+		NoRollback:  notFound,
+		RollbackFor: notFound,
+		When: func() map[string]interface{} {
+			return map[string]interface{}{"code": 1}
+		},
+		Failed: func() (interface{}, error) {
+			return nil, vermouth.NewRuntimeError(409, "conflict")
+		},
+		Panic: func() (map[string]string, error) {
+			panic(&NotFoundError{Resource: "order"})
+		},
+	})
+
+	call := func(path string) *httptest.ResponseRecorder {
+		fake.Reset()
+		req, _ := http.NewRequest("POST", "/rollback"+path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	call("/error")
+	assert.Equal(t, []string{"BEGIN", "ROLLBACK"}, fake.Ops())
+	call("/no")
+	assert.Equal(t, []string{"BEGIN", "COMMIT"}, fake.Ops())
+	call("/for")
+	assert.Equal(t, []string{"BEGIN", "COMMIT"}, fake.Ops())
+	call("/when")
+	assert.Equal(t, []string{"BEGIN", "ROLLBACK"}, fake.Ops())
+	call("/panic")
+	assert.Equal(t, []string{"BEGIN", "COMMIT"}, fake.Ops())
+
+	// 回滚失败时，错误处理器仍然按原始的错误处理
+	fake.FailNext("ROLLBACK")
+	w := call("/failed")
+	assert.Equal(t, []string{"BEGIN", "ROLLBACK"}, fake.Ops())
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Transactional同样适用回滚规则，并返回两个错误
+	fake.Reset()
+	fake.FailNext("ROLLBACK")
+	cause := errors.New("failed")
+	err := vermouth.Transactional(context.Background(), func(ctx context.Context) error {
+		return cause
+	})
+	var txErr *vermouth.TransactionError
+	if assert.True(t, errors.As(err, &txErr)) {
+		assert.Equal(t, cause, txErr.Cause)
+		assert.EqualError(t, txErr.Err, "rollback failed")
+		assert.True(t, errors.Is(err, cause))
+		// 回滚时的错误同样可以匹配
+		assert.True(t, errors.Is(err, fakeTxError{op: "rollback"}))
+		var rollbackErr fakeTxError
+		assert.True(t, errors.As(err, &rollbackErr))
+		assert.Equal(t, "rollback", rollbackErr.op)
+	}
+
+	// 两个错误类型相同时，errors.As优先匹配原始的错误
+	fake.Reset()
+	fake.FailNext("ROLLBACK")
+	err = vermouth.Transactional(context.Background(), func(ctx context.Context) error {
+		return fakeTxError{op: "business"}
+	})
+	var matched fakeTxError
+	if assert.True(t, errors.As(err, &matched)) {
+		assert.Equal(t, "business", matched.op)
+	}
+
+	fake.Reset()
+	err = vermouth.Transactional(context.Background(), func(ctx context.Context) error {
+		return cause
+	}, vermouth.TxOptions{RollbackFor: []string{"NotFoundError"}})
+	assert.Equal(t, cause, err)
+	assert.Equal(t, []string{"BEGIN", "COMMIT"}, fake.Ops())
+}
+
+func TestRollbackRuleDefinition(t *testing.T) {
+	var bad struct {
+		Bad func() `method:"GET" path:"/rollback/bad" transaction:"true" rollback_when:"missing"`
+	}
+	bad.Bad = func() {}
+	err := vermouth.RegisterControllersE(gin.New(), &bad)
+	assert.Error(t, err)
+}
@@ -1,28 +1,299 @@
 package test
 
 import (
-	"github.com/llyb120/vermouth"
-	"github.com/stretchr/testify/assert"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
 )
 
+func get(tl *vermouth.ThreadLocal[string]) string {
+	value, _ := tl.Get()
+	return value
+}
+
 func TestThreadLocal(t *testing.T) {
-	tl := vermouth.NewThreadLocal()
+	tl := vermouth.NewThreadLocal[string]()
 	tl.Set("test")
-	assert.Equal(t, "test", tl.Get())
+	assert.Equal(t, "test", get(tl))
 
 	var wg sync.WaitGroup
 	wg.Add(1)
 	tl.Go(func() {
 		defer wg.Done()
-		assert.Equal(t, "test", tl.Get())
+		assert.Equal(t, "test", get(tl))
 
 		tl.Set("test2")
-		assert.Equal(t, "test2", tl.Get())
+		assert.Equal(t, "test2", get(tl))
+	})
+
+	assert.Equal(t, "test", get(tl))
+
+	wg.Wait()
+}
+
+type threadLocalUser struct {
+	Name string
+}
+
+func TestThreadLocalTyped(t *testing.T) {
+	tl := vermouth.NewThreadLocal[*threadLocalUser]()
+	defer tl.Close()
+	user, ok := tl.Get()
+	assert.Nil(t, user)
+	assert.False(t, ok)
+
+	alice := &threadLocalUser{Name: "alice"}
+	tl.Set(alice)
+	user, ok = tl.Get()
+	assert.True(t, ok)
+	assert.Same(t, alice, user)
+
+	// With执行完毕后恢复之前的值
+	tl.With(&threadLocalUser{Name: "bob"}, func() {
+		user, _ := tl.Get()
+		assert.Equal(t, "bob", user.Name)
+	})
+	user, _ = tl.Get()
+	assert.Same(t, alice, user)
+
+	tl.Remove()
+	_, ok = tl.Get()
+	assert.False(t, ok)
+
+	// 接口类型可以保存nil
+	errs := vermouth.NewThreadLocal[error]()
+	defer errs.Close()
+	errs.Set(nil)
+	err, ok := errs.Get()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestThreadLocalContext(t *testing.T) {
+	tl := vermouth.NewThreadLocal[*threadLocalUser]()
+	defer tl.Close()
+	ctx := context.Background()
+	assert.Equal(t, ctx, tl.ToContext(ctx))
+
+	alice := &threadLocalUser{Name: "alice"}
+	tl.Set(alice)
+	ctx = tl.ToContext(ctx)
+	user, ok := tl.FromContext(ctx)
+	assert.True(t, ok)
+	assert.Same(t, alice, user)
+
+	// 使用context的代码在其他goroutine中调用依赖ThreadLocal的代码
+	done := make(chan *threadLocalUser)
+	go func() {
+		tl.WithContext(ctx, func() {
+			user, _ := tl.Get()
+			done <- user
+		})
+		_, ok := tl.Get()
+		assert.False(t, ok)
+		tl.WithContext(context.Background(), func() {
+			user, _ := tl.Get()
+			done <- user
+		})
+	}()
+	assert.Same(t, alice, <-done)
+	assert.Nil(t, <-done)
+}
+
+func TestThreadLocalNestedGo(t *testing.T) {
+	tl := vermouth.NewThreadLocal[string]()
+	defer tl.Close()
+	tl.Set("root")
+	done := make(chan string)
+	tl.Go(func() {
+		// 子goroutine没有自己的值，孙goroutine仍然可以读取根的值
+		tl.Go(func() {
+			done <- get(tl)
+		})
+	})
+	assert.Equal(t, "root", <-done)
+
+	go func() {
+		done <- get(tl)
+	}()
+	assert.Equal(t, "", <-done)
+}
+
+func TestThreadLocalSnapshot(t *testing.T) {
+	tl := vermouth.NewThreadLocal[string]()
+	defer tl.Close()
+	tl.Set("before")
+	started := make(chan struct{})
+	changed := make(chan struct{})
+	done := make(chan string)
+	tl.Go(func() {
+		close(started)
+		<-changed
+		// 启动时复制的值，不受父协程修改的影响
+		done <- get(tl)
+	})
+	<-started
+	tl.Set("after")
+	close(changed)
+	assert.Equal(t, "before", <-done)
+}
+
+func TestThreadLocalShared(t *testing.T) {
+	tl := vermouth.NewThreadLocalWithOptions[string](vermouth.ThreadLocalOptions{Mode: vermouth.ThreadLocalShared})
+	defer tl.Close()
+	tl.Set("before")
+	changed := make(chan struct{})
+	done := make(chan string)
+	tl.Go(func() {
+		tl.Go(func() {
+			<-changed
+			// 读取祖先协程最新的值
+			done <- get(tl)
+		})
+	})
+	tl.Set("after")
+	close(changed)
+	assert.Equal(t, "after", <-done)
+}
+
+func TestThreadLocalWrap(t *testing.T) {
+	tl := vermouth.NewThreadLocal[string]()
+	defer tl.Close()
+	jobs := make(chan func())
+	results := make(chan string)
+	// 协程池中的worker有自己的值
+	go func() {
+		tl.Set("worker")
+		for job := range jobs {
+			job()
+			results <- get(tl)
+		}
+	}()
+
+	tl.Set("request")
+	jobs <- tl.Wrap(func() {
+		results <- get(tl)
+	})
+	assert.Equal(t, "request", <-results)
+	// 执行完毕后恢复worker原来的值
+	assert.Equal(t, "worker", <-results)
+
+	// 定时器的回调
+	timer := make(chan string)
+	time.AfterFunc(time.Millisecond, tl.Wrap(func() {
+		timer <- get(tl)
+	}))
+	assert.Equal(t, "request", <-timer)
+	close(jobs)
+}
+
+func TestThreadLocalExpiry(t *testing.T) {
+	tl := vermouth.NewThreadLocalWithTTL[string](10 * time.Millisecond)
+	defer tl.Close()
+	done := make(chan string)
+	go func() {
+		tl.Set("value")
+		time.Sleep(50 * time.Millisecond)
+		done <- get(tl)
+	}()
+	assert.Equal(t, "", <-done)
+	// 重复关闭不会panic
+	tl.Close()
+	tl.Close()
+}
+
+func TestThreadLocalConcurrentRequests(t *testing.T) {
+	tl := vermouth.NewThreadLocal[string]()
+	defer tl.Close()
+	r := gin.New()
+	r.GET("/thread-local/:id", func(c *gin.Context) {
+		id := c.Param("id")
+		tl.Set(id)
+		var wg sync.WaitGroup
+		values := make([]string, 4)
+		for i := range values {
+			i := i
+			wg.Add(1)
+			tl.Go(func() {
+				defer wg.Done()
+				values[i] = get(tl)
+			})
+		}
+		wg.Wait()
+		for _, value := range values {
+			if value != id {
+				c.String(http.StatusInternalServerError, "got %v", value)
+				return
+			}
+		}
+		if get(tl) != id {
+			c.String(http.StatusInternalServerError, "got %v", get(tl))
+			return
+		}
+		c.String(http.StatusOK, id)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 40; j++ {
+				id := fmt.Sprintf("%d-%d", i, j)
+				req, _ := http.NewRequest("GET", "/thread-local/"+id, nil)
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				assert.Equal(t, http.StatusOK, w.Code)
+				assert.Equal(t, id, w.Body.String())
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func BenchmarkThreadLocalGet(b *testing.B) {
+	tl := vermouth.NewThreadLocal[string]()
+	defer tl.Close()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		tl.Set("value")
+		for pb.Next() {
+			tl.Get()
+		}
 	})
+}
 
-	assert.Equal(t, "test", tl.Get())
+func BenchmarkThreadLocalSet(b *testing.B) {
+	tl := vermouth.NewThreadLocal[string]()
+	defer tl.Close()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			tl.Set("value")
+		}
+	})
+}
 
+func BenchmarkThreadLocalGo(b *testing.B) {
+	tl := vermouth.NewThreadLocal[string]()
+	defer tl.Close()
+	tl.Set("value")
+	b.ReportAllocs()
+	var wg sync.WaitGroup
+	for i := 0; i < b.N; i++ {
+		wg.Add(1)
+		tl.Go(func() {
+			tl.Get()
+			wg.Done()
+		})
+	}
 	wg.Wait()
 }
@@ -0,0 +1,104 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
+)
+
+type HookController struct {
+	_      interface{}                      `path:"/hook"`
+	Commit func(ctx context.Context) string `method:"POST" path:"/commit" transaction:"true"`
+	Fail   func(ctx context.Context) string `method:"POST" path:"/fail" transaction:"true"`
+}
+
+func TestTransactionHook(t *testing.T) {
+	db, fake := newFakeDB(t)
+	old := vermouth.GetDB()
+	vermouth.SetDB(db)
+	defer vermouth.SetDB(old)
+
+	var events []string
+	record := func(event string) func() {
+		return func() {
+			events = append(events, event+" "+fake.Ops()[len(fake.Ops())-1])
+		}
+	}
+	r := gin.New()
+	vermouth.RegisterControllers(r, &HookController{
+		Commit: func(ctx context.Context) string {
+			vermouth.OnCommit(ctx, func() {
+				panic("hook failed")
+			})
+			vermouth.OnCommit(ctx, record("mail"))
+			vermouth.OnRollback(ctx, record("never"))
+			// savepoint回滚后，其中注册的提交回调不会执行
+			vermouth.Transactional(ctx, func(ctx context.Context) error {
+				vermouth.OnCommit(ctx, record("never"))
+				vermouth.OnRollback(ctx, record("savepoint"))
+				return errors.New("failed")
+			}, vermouth.TxOptions{Propagation: vermouth.PropagationNested})
+			vermouth.Transactional(ctx, func(ctx context.Context) error {
+				vermouth.OnCommit(ctx, record("nested"))
+				return nil
+			}, vermouth.TxOptions{Propagation: vermouth.PropagationNested})
+			assert.Empty(t, events[1:])
+			return "ok"
+		},
+		Fail: func(ctx context.Context) string {
+			vermouth.OnCommit(ctx, record("never"))
+			vermouth.OnRollback(ctx, record("compensate"))
+			panic(errors.New("failed"))
+		},
+	})
+
+	req, _ := http.NewRequest("POST", "/hook/commit", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"savepoint tx: ROLLBACK TO SAVEPOINT vermouth_sp_1", "mail COMMIT", "nested COMMIT"}, events)
+
+	events = nil
+	req, _ = http.NewRequest("POST", "/hook/fail", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, []string{"compensate ROLLBACK"}, events)
+
+	// 没有事务时，提交回调立即执行
+	events = nil
+	vermouth.OnCommit(context.Background(), record("direct"))
+	vermouth.OnRollback(context.Background(), record("never"))
+	assert.Len(t, events, 1)
+}
+
+func TestTransactionHookInGroup(t *testing.T) {
+	db, _ := newFakeDB(t)
+	old := vermouth.GetDB()
+	vermouth.SetDB(db)
+	defer vermouth.SetDB(old)
+
+	var committed, rolledBack int32
+	err := vermouth.Transactional(context.Background(), func(ctx context.Context) error {
+		// 子协程继承当前的事务，同时注册回调
+		g := vermouth.NewGroup()
+		for i := 0; i < 8; i++ {
+			g.Go(func() error {
+				vermouth.OnCommit(context.Background(), func() { atomic.AddInt32(&committed, 1) })
+				vermouth.OnRollback(ctx, func() { atomic.AddInt32(&rolledBack, 1) })
+				return nil
+			})
+		}
+		return g.Wait()
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(8), atomic.LoadInt32(&committed))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&rolledBack))
+}
@@ -0,0 +1,181 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/llyb120/vermouth"
+	"github.com/stretchr/testify/assert"
+)
+
+type orderService struct{}
+
+// 服务层不接收事务参数，直接使用当前的事务
+func (s *orderService) Create(ctx context.Context) error {
+	return vermouth.Transactional(ctx, func(ctx context.Context) error {
+		_, err := vermouth.Tx(ctx).Exec("INSERT INTO orders")
+		return err
+	})
+}
+
+func (s *orderService) Log(ctx context.Context) error {
+	return vermouth.Transactional(ctx, func(ctx context.Context) error {
+		if vermouth.CurrentTx() == nil {
+			return errors.New("no tx")
+		}
+		_, err := vermouth.Tx(ctx).Exec("FAIL INSERT INTO logs")
+		return err
+	}, vermouth.TxOptions{Propagation: vermouth.PropagationNested})
+}
+
+type PropagationController struct {
+	_     interface{}                      `path:"/propagation"`
+	Order func(ctx context.Context) string `method:"POST" path:"/order" transaction:"true"`
+}
+
+func TestTransactionPropagation(t *testing.T) {
+	db, fake := newFakeDB(t)
+	old := vermouth.GetDB()
+	vermouth.SetDB(db)
+	defer vermouth.SetDB(old)
+
+	service := &orderService{}
+	r := gin.New()
+	vermouth.RegisterControllers(r, &PropagationController{
+		Order: func(ctx context.Context) string {
+			if err := service.Create(ctx); err != nil {
+				panic(err)
+			}
+			// 嵌套事务失败只回滚到savepoint
+			assert.Error(t, service.Log(ctx))
+			return "ok"
+		},
+	})
+
+	req, _ := http.NewRequest("POST", "/propagation/order", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{
+		"BEGIN",
+		"tx: INSERT INTO orders",
+		"tx: SAVEPOINT vermouth_sp_1",
+		"tx: FAIL INSERT INTO logs",
+		"tx: ROLLBACK TO SAVEPOINT vermouth_sp_1",
+		"COMMIT",
+	}, fake.Ops())
+}
+
+func TestTransactional(t *testing.T) {
+	db, fake := newFakeDB(t)
+	// REQUIRES_NEW 需要第二个连接
+	db.SetMaxOpenConns(2)
+	old := vermouth.GetDB()
+	vermouth.SetDB(db)
+	defer vermouth.SetDB(old)
+
+	ctx := context.Background()
+	assert.Nil(t, vermouth.Tx(ctx))
+
+	// 返回error时回滚
+	err := vermouth.Transactional(ctx, func(ctx context.Context) error {
+		assert.NotNil(t, vermouth.Tx(ctx))
+		return errors.New("failed")
+	})
+	assert.EqualError(t, err, "failed")
+	assert.Equal(t, []string{"BEGIN", "ROLLBACK"}, fake.Ops())
+	assert.Nil(t, vermouth.CurrentTx())
+
+	// 异常时回滚并继续抛出
+	fake.Reset()
+	assert.Panics(t, func() {
+		vermouth.Transactional(ctx, func(ctx context.Context) error {
+			panic("boom")
+		})
+	})
+	assert.Equal(t, []string{"BEGIN", "ROLLBACK"}, fake.Ops())
+
+	// REQUIRED 加入外层事务，REQUIRES_NEW 使用新的事务，NOT_SUPPORTED 不使用事务
+	fake.Reset()
+	err = vermouth.Transactional(ctx, func(ctx context.Context) error {
+		outer := vermouth.Tx(ctx)
+		vermouth.Transactional(ctx, func(ctx context.Context) error {
+			assert.Same(t, outer, vermouth.Tx(ctx))
+			return nil
+		})
+		vermouth.Transactional(ctx, func(ctx context.Context) error {
+			assert.NotSame(t, outer, vermouth.Tx(ctx))
+			return errors.New("inner failed")
+		}, vermouth.TxOptions{Propagation: vermouth.PropagationRequiresNew})
+		vermouth.Transactional(ctx, func(ctx context.Context) error {
+			assert.Nil(t, vermouth.Tx(ctx))
+			assert.Nil(t, vermouth.CurrentTx())
+			return nil
+		}, vermouth.TxOptions{Propagation: vermouth.PropagationNotSupported})
+		assert.Same(t, outer, vermouth.CurrentTx())
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"BEGIN", "BEGIN", "ROLLBACK", "COMMIT"}, fake.Ops())
+}
+
+type RollbackOnlyController struct {
+	_     interface{}                      `path:"/rollback-only"`
+	Order func(ctx context.Context) string `method:"POST" path:"/order" transaction:"true"`
+}
+
+func TestTransactionRollbackOnly(t *testing.T) {
+	db, fake := newFakeDB(t)
+	old := vermouth.GetDB()
+	vermouth.SetDB(db)
+	defer vermouth.SetDB(old)
+
+	ctx := context.Background()
+	failed := func(ctx context.Context) error {
+		vermouth.Tx(ctx).Exec("INSERT INTO orders")
+		return errors.New("failed")
+	}
+	// 加入的事务失败后，外层忽略了错误也会回滚
+	err := vermouth.Transactional(ctx, func(ctx context.Context) error {
+		assert.EqualError(t, vermouth.Transactional(ctx, failed), "failed")
+		return nil
+	})
+	assert.Equal(t, vermouth.ErrRollbackOnly, err)
+	assert.Equal(t, []string{"BEGIN", "tx: INSERT INTO orders", "ROLLBACK"}, fake.Ops())
+
+	// 回滚到savepoint后不再影响外层
+	fake.Reset()
+	err = vermouth.Transactional(ctx, func(ctx context.Context) error {
+		vermouth.Transactional(ctx, func(ctx context.Context) error {
+			return vermouth.Transactional(ctx, failed)
+		}, vermouth.TxOptions{Propagation: vermouth.PropagationNested})
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"BEGIN",
+		"tx: SAVEPOINT vermouth_sp_1",
+		"tx: INSERT INTO orders",
+		"tx: ROLLBACK TO SAVEPOINT vermouth_sp_1",
+		"COMMIT",
+	}, fake.Ops())
+
+	// 控制器的事务同样回滚
+	fake.Reset()
+	r := gin.New()
+	vermouth.RegisterControllers(r, &RollbackOnlyController{
+		Order: func(ctx context.Context) string {
+			vermouth.Transactional(ctx, failed)
+			return "ok"
+		},
+	})
+	req, _ := http.NewRequest("POST", "/rollback-only/order", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, []string{"BEGIN", "tx: INSERT INTO orders", "ROLLBACK"}, fake.Ops())
+}
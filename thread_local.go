@@ -1,94 +1,420 @@
 package vermouth
 
 import (
+	"context"
+	"log"
 	"runtime"
-	"strconv"
-	"strings"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type ThreadLocal struct {
-	mu       sync.RWMutex
-	store    map[uint64]interface{}
-	parentID map[uint64]uint64
-	expiry   map[uint64]time.Time
-	ttl      time.Duration
+// 分片的数量，必须是2的幂
+const threadLocalShards = 64
+
+// ThreadLocalMode 子协程如何继承父协程的值
+type ThreadLocalMode int
+
+const (
+	// ThreadLocalSnapshot 默认，启动时复制父协程当前的值，之后互不影响
+	ThreadLocalSnapshot ThreadLocalMode = iota
+	// ThreadLocalShared 读取父协程最新的值，父协程重新Set后子协程也能看到
+	ThreadLocalShared
+)
+
+// ThreadLocalOptions 创建ThreadLocal时的选项，零值的字段使用默认值
+type ThreadLocalOptions struct {
+	// 超过该时间没有访问的值会被清理，默认30分钟
+	TTL  time.Duration
+	Mode ThreadLocalMode
+}
+
+// ThreadLocal 协程上下文，每个goroutine有自己的值
+type ThreadLocal[T any] struct {
+	store *threadLocalStore
+}
+
+func NewThreadLocalWithOptions[T any](options ThreadLocalOptions) *ThreadLocal[T] {
+	return &ThreadLocal[T]{store: newThreadLocalStore(options)}
+}
+
+func NewThreadLocalWithTTL[T any](ttl time.Duration) *ThreadLocal[T] {
+	return NewThreadLocalWithOptions[T](ThreadLocalOptions{TTL: ttl})
+}
+
+func NewThreadLocal[T any]() *ThreadLocal[T] {
+	// 默认30分钟
+	return NewThreadLocalWithOptions[T](ThreadLocalOptions{})
+}
+
+func (tl *ThreadLocal[T]) Set(value T) {
+	tl.store.set(value)
+}
+
+// Get 获取当前goroutine的值，没有设置过时返回零值和false
+func (tl *ThreadLocal[T]) Get() (T, bool) {
+	value, ok := tl.store.lookup(goID())
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	// T为接口时，值可能是nil
+	v, _ := value.(T)
+	return v, true
+}
+
+// Remove 删除当前goroutine的值，也不再读取父协程的值
+func (tl *ThreadLocal[T]) Remove() {
+	tl.store.clear(goID())
+}
+
+// With 在fn执行期间使用value，执行完毕后恢复之前的值
+func (tl *ThreadLocal[T]) With(value T, fn func()) {
+	defer tl.store.swap(value)()
+	fn()
+}
+
+// Wrap 捕获当前goroutine的值，返回的函数无论在哪个goroutine中执行，都可以读取捕获的值
+// 适用于交给协程池、定时器的回调，执行完毕后恢复执行者原来的值
+func (tl *ThreadLocal[T]) Wrap(f func()) func() {
+	return tl.store.wrap(f)
+}
+
+// Go 启动goroutine，新的goroutine继承当前goroutine的值，结束后自动清理
+// panic只记录日志，不会导致进程退出，需要等待结果或者处理panic时使用Group
+func (tl *ThreadLocal[T]) Go(f func()) {
+	go tl.store.wrap(func() {
+		defer func() {
+			if e := recover(); e != nil {
+				log.Printf("vermouth: goroutine panic: %v\n%s", e, debug.Stack())
+			}
+		}()
+		f()
+	})()
+}
+
+// Close 停止清理过期数据的goroutine，请求结束时也不再清理
+func (tl *ThreadLocal[T]) Close() {
+	tl.store.close()
+}
+
+// 每个ThreadLocal在context中使用不同的key
+type threadLocalContextKey struct {
+	store *threadLocalStore
+}
+
+// ToContext 将当前goroutine的值复制到context中，没有值时返回原来的context
+// 用于调用使用context传递数据的代码
+func (tl *ThreadLocal[T]) ToContext(ctx context.Context) context.Context {
+	value, ok := tl.Get()
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, threadLocalContextKey{tl.store}, value)
+}
+
+// FromContext 读取ToContext复制到context中的值
+func (tl *ThreadLocal[T]) FromContext(ctx context.Context) (T, bool) {
+	value, ok := ctx.Value(threadLocalContextKey{tl.store}).(T)
+	return value, ok
+}
+
+// WithContext 在fn执行期间使用context中的值，context中没有值时不改变当前的值
+// 用于在使用context的代码中调用依赖ThreadLocal的代码
+func (tl *ThreadLocal[T]) WithContext(ctx context.Context, fn func()) {
+	value, ok := tl.FromContext(ctx)
+	if !ok {
+		fn()
+		return
+	}
+	tl.With(value, fn)
+}
+
+// 按goroutine保存值，不区分类型，ThreadLocal[T]在此基础上提供类型安全的接口
+type threadLocalStore struct {
+	shards [threadLocalShards]threadLocalShard
+	ttl    int64
+	mode   ThreadLocalMode
+	done   chan struct{}
+	once   sync.Once
+}
+
+// 每个分片单独加锁，不同goroutine之间很少竞争
+type threadLocalShard struct {
+	mu     sync.RWMutex
+	values map[uint64]*threadLocalEntry
+	// 共享模式下，指向拥有值的祖先goroutine
+	parents map[uint64]*threadLocalEntry
+}
+
+type threadLocalEntry struct {
+	value  interface{}
+	parent uint64
+	// 过期时间，UnixNano，读取时只需要原子更新
+	expiry int64
+}
+
+func (e *threadLocalEntry) touch(ttl int64) {
+	atomic.StoreInt64(&e.expiry, time.Now().UnixNano()+ttl)
 }
 
-func NewThreadLocalWithTTL(ttl time.Duration) *ThreadLocal {
-	tl := &ThreadLocal{
-		store:    make(map[uint64]interface{}),
-		parentID: make(map[uint64]uint64),
-		expiry:   make(map[uint64]time.Time),
-		ttl:      ttl,
+func newThreadLocalStore(options ThreadLocalOptions) *threadLocalStore {
+	if options.TTL <= 0 {
+		options.TTL = 30 * time.Minute
 	}
-	go tl.cleanup()
+	tl := &threadLocalStore{
+		ttl:  int64(options.TTL),
+		mode: options.Mode,
+		done: make(chan struct{}),
+	}
+	for i := range tl.shards {
+		tl.shards[i].values = make(map[uint64]*threadLocalEntry)
+		tl.shards[i].parents = make(map[uint64]*threadLocalEntry)
+	}
+	go tl.cleanup(options.TTL)
+	threadLocals.Store(tl, struct{}{})
 	return tl
 }
 
-func NewThreadLocal() *ThreadLocal {
-	// 默认30分钟
-	return NewThreadLocalWithTTL(30 * time.Minute)
+func (tl *threadLocalStore) shard(id uint64) *threadLocalShard {
+	return &tl.shards[id&(threadLocalShards-1)]
 }
 
-func (tl *ThreadLocal) Set(value interface{}) {
-	tl.mu.Lock()
-	defer tl.mu.Unlock()
+func (tl *threadLocalStore) set(value interface{}) {
 	id := goID()
-	tl.store[id] = value
-	tl.expiry[id] = time.Now().Add(tl.ttl)
+	s := tl.shard(id)
+	entry := &threadLocalEntry{value: value}
+	entry.touch(tl.ttl)
+	s.mu.Lock()
+	s.values[id] = entry
+	s.mu.Unlock()
 }
 
-func (tl *ThreadLocal) Get() interface{} {
-	tl.mu.RLock()
-	defer tl.mu.RUnlock()
-	id := goID()
-	if value, ok := tl.store[id]; ok {
-		tl.expiry[id] = time.Now().Add(tl.ttl)
-		return value
+// 先找当前goroutine的值，共享模式下没有时找祖先goroutine的值
+func (tl *threadLocalStore) lookup(id uint64) (interface{}, bool) {
+	s := tl.shard(id)
+	s.mu.RLock()
+	entry, ok := s.values[id]
+	link, linked := s.parents[id]
+	s.mu.RUnlock()
+	if ok {
+		entry.touch(tl.ttl)
+		return entry.value, true
+	}
+	if !linked {
+		return nil, false
+	}
+	link.touch(tl.ttl)
+	ps := tl.shard(link.parent)
+	ps.mu.RLock()
+	entry, ok = ps.values[link.parent]
+	ps.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	entry.touch(tl.ttl)
+	return entry.value, true
+}
+
+// 当前goroutine的值属于哪个goroutine，自己没有值时使用祖先goroutine
+func (tl *threadLocalStore) owner(id uint64) (uint64, bool) {
+	s := tl.shard(id)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.values[id]; ok {
+		return id, true
+	}
+	if link, ok := s.parents[id]; ok {
+		return link.parent, true
+	}
+	return 0, false
+}
+
+// 设置goroutine的值和指向祖先的链接，为nil时删除，返回恢复之前状态的函数
+func (tl *threadLocalStore) bind(id uint64, entry, link *threadLocalEntry) func() {
+	s := tl.shard(id)
+	s.mu.Lock()
+	previous, hasPrevious := s.values[id]
+	previousLink, hasPreviousLink := s.parents[id]
+	if entry != nil {
+		s.values[id] = entry
+	} else {
+		delete(s.values, id)
+	}
+	if link != nil {
+		s.parents[id] = link
+	} else {
+		delete(s.parents, id)
+	}
+	s.mu.Unlock()
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if hasPrevious {
+			previous.touch(tl.ttl)
+			s.values[id] = previous
+		} else {
+			delete(s.values, id)
+		}
+		if hasPreviousLink {
+			previousLink.touch(tl.ttl)
+			s.parents[id] = previousLink
+		} else {
+			delete(s.parents, id)
+		}
+	}
+}
+
+// 捕获goroutine的值，返回的函数将捕获的值设置到执行者的goroutine中，并返回恢复用的函数
+func (tl *threadLocalStore) capture(id uint64) func(id uint64) func() {
+	var entry, link *threadLocalEntry
+	if tl.mode == ThreadLocalShared {
+		if owner, ok := tl.owner(id); ok {
+			link = &threadLocalEntry{parent: owner}
+		}
+	} else if value, ok := tl.lookup(id); ok {
+		entry = &threadLocalEntry{value: value}
 	}
-	if parentID, ok := tl.parentID[id]; ok {
-		tl.expiry[parentID] = time.Now().Add(tl.ttl)
-		return tl.store[parentID]
+	// 可能被多次调用，每次使用新的entry
+	return func(id uint64) func() {
+		var current, currentLink *threadLocalEntry
+		if entry != nil {
+			current = &threadLocalEntry{value: entry.value}
+			current.touch(tl.ttl)
+		}
+		if link != nil {
+			currentLink = &threadLocalEntry{parent: link.parent}
+			currentLink.touch(tl.ttl)
+		}
+		return tl.bind(id, current, currentLink)
 	}
-	return nil
 }
 
-func (tl *ThreadLocal) Go(f func()) {
-	parentID := goID()
-	go func() {
-		tl.mu.Lock()
-		tl.parentID[goID()] = parentID
-		tl.mu.Unlock()
+// 捕获当前goroutine的值，返回的函数在执行期间使用捕获的值，执行完毕后恢复执行者原来的值
+func (tl *threadLocalStore) wrap(f func()) func() {
+	apply := tl.capture(goID())
+	return func() {
+		defer apply(goID())()
 		f()
-	}()
+	}
 }
 
-func (tl *ThreadLocal) cleanup() {
+// 捕获当前goroutine在所有ThreadLocal中的值，用于启动继承所有值的goroutine
+func wrapThreadLocals(f func()) func() {
+	id := goID()
+	var captures []func(uint64) func()
+	threadLocals.Range(func(key, _ interface{}) bool {
+		captures = append(captures, key.(*threadLocalStore).capture(id))
+		return true
+	})
+	return func() {
+		id := goID()
+		for _, apply := range captures {
+			defer apply(id)()
+		}
+		f()
+	}
+}
+
+// 设置当前goroutine的值，返回恢复之前状态的函数
+func (tl *threadLocalStore) swap(value interface{}) func() {
+	entry := &threadLocalEntry{value: value}
+	entry.touch(tl.ttl)
+	return tl.bind(goID(), entry, nil)
+}
+
+// 删除goroutine的值和指向祖先的链接
+func (tl *threadLocalStore) clear(id uint64) {
+	s := tl.shard(id)
+	s.mu.Lock()
+	delete(s.values, id)
+	delete(s.parents, id)
+	s.mu.Unlock()
+}
+
+// 停止清理过期数据的goroutine，请求结束时也不再清理
+func (tl *threadLocalStore) close() {
+	tl.once.Do(func() {
+		threadLocals.Delete(tl)
+		close(tl.done)
+	})
+}
+
+// 所有没有关闭的ThreadLocal，请求结束时统一清理
+var threadLocals sync.Map
+
+// 每个goroutine打开的作用域的层数
+var threadLocalScopes sync.Map
+
+// 打开请求的作用域，最外层的作用域结束时清理当前goroutine在所有ThreadLocal中的值
+// 同一个连接上的请求由同一个goroutine处理，不清理时后面的请求会读取到之前的值
+func openThreadLocalScope() func() {
+	id := goID()
+	value, _ := threadLocalScopes.LoadOrStore(id, new(int))
+	// 只有当前goroutine会访问
+	depth := value.(*int)
+	*depth++
+	return func() {
+		if *depth--; *depth > 0 {
+			return
+		}
+		threadLocalScopes.Delete(id)
+		threadLocals.Range(func(key, _ interface{}) bool {
+			key.(*threadLocalStore).clear(id)
+			return true
+		})
+	}
+}
+
+func (tl *threadLocalStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	for {
-		time.Sleep(tl.ttl)
-		tl.mu.Lock()
-		now := time.Now()
-		for id, expiry := range tl.expiry {
-			if now.After(expiry) {
-				delete(tl.store, id)
-				delete(tl.expiry, id)
-				delete(tl.parentID, id)
+		select {
+		case <-tl.done:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now().UnixNano()
+		for i := range tl.shards {
+			s := &tl.shards[i]
+			s.mu.Lock()
+			for id, entry := range s.values {
+				if now > atomic.LoadInt64(&entry.expiry) {
+					delete(s.values, id)
+				}
+			}
+			for id, link := range s.parents {
+				if now > atomic.LoadInt64(&link.expiry) {
+					delete(s.parents, id)
+				}
 			}
+			s.mu.Unlock()
 		}
-		tl.mu.Unlock()
 	}
 }
 
-// 获取当前 goroutine 的 ID
+// runtime.Stack的缓冲区，避免每次分配
+var goIDBuffers = sync.Pool{New: func() interface{} {
+	return new([64]byte)
+}}
+
+// 获取当前 goroutine 的 ID，解析 runtime.Stack 的第一行 "goroutine 123 [running]:"
+// 没有转换为字符串，不再分配内存，但 runtime.Stack 本身需要回溯当前的调用栈，每次调用仍然需要约2µs，
+// 是 Get/Set 的主要开销，热点代码中应该读取一次后复用
 func goID() uint64 {
-	var buf [64]byte
+	buf := goIDBuffers.Get().(*[64]byte)
 	n := runtime.Stack(buf[:], false)
-	idField := strings.Fields(strings.TrimPrefix(string(buf[:n]), "goroutine "))[0]
-	id, err := strconv.ParseUint(idField, 10, 64)
-	if err != nil {
-		return 0
+	const prefix = len("goroutine ")
+	var id uint64
+	for i := prefix; i < n; i++ {
+		c := buf[i]
+		if c < '0' || c > '9' {
+			break
+		}
+		id = id*10 + uint64(c-'0')
 	}
+	goIDBuffers.Put(buf)
 	return id
 }
@@ -1,57 +1,624 @@
 package vermouth
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"runtime/debug"
+	"strings"
 	"sync"
 )
 
 var once sync.Once
 
+// Propagation 事务的传播方式，与Spring保持一致
+type Propagation int
+
+const (
+	// PropagationRequired 有事务则加入，没有则新建，默认值
+	PropagationRequired Propagation = iota
+	// PropagationRequiresNew 总是新建一个独立的事务，与外层事务互不影响
+	PropagationRequiresNew
+	// PropagationNested 有事务时使用savepoint，回滚只影响内层，没有事务时同Required
+	PropagationNested
+	// PropagationNotSupported 以非事务的方式执行
+	PropagationNotSupported
+)
+
+type TxOptions struct {
+	Propagation Propagation
+	// 数据源的名字，为空时使用默认数据源
+	DB string
+	// 隔离级别和只读，只在新建事务时生效
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+	// 回滚规则，按错误链上的类型名匹配，例如 NotFoundError 或 *pkg.NotFoundError
+	// 设置了RollbackFor时只有匹配的错误才回滚，NoRollbackFor优先级更高，异常只受NoRollbackFor影响
+	RollbackFor   []string
+	NoRollbackFor []string
+}
+
+// TransactionError 回滚或提交失败时返回，同时包含原始的错误
+type TransactionError struct {
+	// 导致回滚的错误，由规则触发的回滚可能为nil
+	Cause error
+	// 回滚或提交时的错误
+	Err error
+	// rollback 或 commit
+	Op string
+}
+
+func (e *TransactionError) Error() string {
+	if e.Cause == nil {
+		return fmt.Sprintf("%s failed: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("%v (%s failed: %v)", e.Cause, e.Op, e.Err)
+}
+
+// 错误处理器按原始的错误匹配
+func (e *TransactionError) Unwrap() error {
+	if e.Cause == nil {
+		return e.Err
+	}
+	return e.Cause
+}
+
+// Is 同时匹配回滚或提交时的错误，例如errors.Is(err, driver.ErrBadConn)
+func (e *TransactionError) Is(target error) bool {
+	return e.Err != nil && errors.Is(e.Err, target)
+}
+
+// As 优先匹配原始的错误，其次匹配回滚或提交时的错误
+func (e *TransactionError) As(target interface{}) bool {
+	if e.Cause != nil && errors.As(e.Cause, target) {
+		return true
+	}
+	return e.Err != nil && errors.As(e.Err, target)
+}
+
+// 根据返回值判断是否需要回滚的规则，通过rollback_when tag引用
+var rollbackRules = map[string]func(ctx *Context) bool{}
+
+// RegisterRollbackRule 注册回滚规则，返回true时回滚事务，需要在注册控制器之前调用
+//
+//	vermouth.RegisterRollbackRule("failed", func(ctx *vermouth.Context) bool {
+//		res, ok := ctx.Result[0].(*Response)
+//		return ok && res.Code != 0
+//	})
+func RegisterRollbackRule(name string, fn func(ctx *Context) bool) {
+	rollbackRules[name] = fn
+}
+
+// 事务状态，嵌套事务共用同一个物理事务
+type txState struct {
+	tx *sql.Tx
+	// 数据源的名字
+	db string
+	// 开启事务时的选项
+	options TxOptions
+	// 用于生成savepoint的名字
+	savepoints int
+	// 当前savepoint范围内注册的回调
+	hooks *txHooks
+	// 加入的事务失败后标记为只能回滚，最外层结束时不再提交
+	rollbackOnly bool
+	// 保护savepoints、hooks和rollbackOnly，Group中的子协程共享同一个事务
+	mu sync.Mutex
+}
+
+// ErrRollbackOnly 加入外层事务的调用失败后，即使外层忽略了错误，事务也会回滚并返回该错误
+var ErrRollbackOnly = errors.New("transaction rolled back because it has been marked as rollback-only")
+
+// 事务结束后执行的回调
+type txHooks struct {
+	commit   []func()
+	rollback []func()
+}
+
+type txContextKey struct {
+	db string
+}
+
+// 最内层的事务，不区分数据源，用于注册回调
+type activeTxContextKey struct{}
+
+// 当前协程的事务，用于无法传递context的场景
+type txLocal struct {
+	// 按数据源的名字保存
+	states map[string]*txState
+	active *txState
+}
+
+var currentTxLocal = NewThreadLocal[*txLocal]()
+
+// 存放在请求上下文中的key，默认数据源保持原来的名字
+func txKey(prefix string, db string) string {
+	if db == "" {
+		return prefix
+	}
+	return prefix + ":" + db
+}
+
+// 控制器中注入的context.Context，包含当前请求的事务
+func requestContext(aopContext *Context) context.Context {
+	ctx := aopContext.HttpContext.Request().Context()
+	name := aopContext.ControllerInformation.TxOptions.DB
+	if state, ok := aopContext.HttpContext.Get(txKey("Vermouth:txState", name)); ok {
+		ctx = context.WithValue(ctx, txContextKey{db: name}, state)
+		ctx = context.WithValue(ctx, activeTxContextKey{}, state)
+	}
+	return ctx
+}
+
+// 从context中获取事务状态，found为false表示context中没有事务信息
+// 兼容直接传入*gin.Context的写法
+func txStateFromContext(ctx context.Context, name string) (state *txState, found bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	if v := ctx.Value(txContextKey{db: name}); v != nil {
+		return v.(*txState), true
+	}
+	if state, ok := ctx.Value(txKey("Vermouth:txState", name)).(*txState); ok {
+		return state, true
+	}
+	return nil, false
+}
+
+func currentTxLocalValue() *txLocal {
+	local, _ := currentTxLocal.Get()
+	if local == nil {
+		return &txLocal{}
+	}
+	return local
+}
+
+// 依次从context和当前协程中查找事务
+func lookupTxState(ctx context.Context, name string) *txState {
+	if state, found := txStateFromContext(ctx, name); found {
+		return state
+	}
+	return currentTxLocalValue().states[name]
+}
+
+// 查找最内层的事务，ctx中没有时从当前协程中查找
+func activeTxState(ctx context.Context) *txState {
+	if ctx != nil {
+		if v := ctx.Value(activeTxContextKey{}); v != nil {
+			return v.(*txState)
+		}
+	}
+	return currentTxLocalValue().active
+}
+
+// OnCommit 注册事务提交成功后执行的回调，没有事务时立即执行
+// 在savepoint中注册的回调，savepoint回滚后不会执行
+func OnCommit(ctx context.Context, fn func()) {
+	state := activeTxState(ctx)
+	if state == nil {
+		runHooks([]func(){fn})
+		return
+	}
+	state.addHook(true, fn)
+}
+
+// OnRollback 注册事务回滚后执行的回调，没有事务时不会执行
+func OnRollback(ctx context.Context, fn func()) {
+	if state := activeTxState(ctx); state != nil {
+		state.addHook(false, fn)
+	}
+}
+
+// 在当前savepoint范围内注册回调
+func (state *txState) addHook(commit bool, fn func()) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if commit {
+		state.hooks.commit = append(state.hooks.commit, fn)
+	} else {
+		state.hooks.rollback = append(state.hooks.rollback, fn)
+	}
+}
+
+// 替换当前savepoint范围内的回调，返回之前的回调
+func (state *txState) swapHooks(hooks *txHooks) *txHooks {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	previous := state.hooks
+	state.hooks = hooks
+	return previous
+}
+
+// 复制当前注册的回调
+func (state *txState) copyHooks() txHooks {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return *state.hooks
+}
+
+// 依次执行回调，回调中的异常只记录日志，不影响其他回调和请求
+func runHooks(hooks []func()) {
+	for _, fn := range hooks {
+		func() {
+			defer func() {
+				if e := recover(); e != nil {
+					log.Printf("vermouth: transaction hook panic: %v\n%s", e, debug.Stack())
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+// Tx 获取context中默认数据源的事务，没有则从当前协程中查找，都没有时返回nil
+func Tx(ctx context.Context) *sql.Tx {
+	return NamedTx(ctx, "")
+}
+
+// NamedTx 获取指定数据源的事务，ctx可以为nil
+func NamedTx(ctx context.Context, name string) *sql.Tx {
+	if state := lookupTxState(ctx, name); state != nil {
+		return state.tx
+	}
+	return nil
+}
+
+// CurrentTx 获取当前协程默认数据源的事务，没有时返回nil
+func CurrentTx() *sql.Tx {
+	return NamedTx(nil, "")
+}
+
+// Transactional 在事务中执行fn，fn返回error或者抛出异常时回滚
+// 传给fn的context包含了事务，可以继续传给mapper或者嵌套调用Transactional
+func Transactional(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOptions) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var options TxOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	current := lookupTxState(ctx, options.DB)
+	switch options.Propagation {
+	case PropagationRequired:
+		if current != nil {
+			return joinTx(ctx, options, current, fn)
+		}
+	case PropagationNested:
+		if current != nil {
+			return runInSavepoint(ctx, current, fn)
+		}
+	case PropagationNotSupported:
+		return withTxState(ctx, options.DB, nil, fn)
+	case PropagationRequiresNew:
+	default:
+		return fmt.Errorf("vermouth: unsupported propagation %d", options.Propagation)
+	}
+	state, err := beginTx(ctx, options)
+	if err != nil {
+		return err
+	}
+	return runInTx(ctx, state, fn)
+}
+
+func beginTx(ctx context.Context, options TxOptions) (*txState, error) {
+	db := GetNamedDB(options.DB)
+	if db == nil {
+		if options.DB != "" {
+			return nil, fmt.Errorf("vermouth: database %s not found, call SetNamedDB first", options.DB)
+		}
+		return nil, fmt.Errorf("vermouth: no database, call SetDB first")
+	}
+	var txOptions *sql.TxOptions
+	if options.Isolation != sql.LevelDefault || options.ReadOnly {
+		txOptions = &sql.TxOptions{Isolation: options.Isolation, ReadOnly: options.ReadOnly}
+	}
+	tx, err := db.BeginTx(ctx, txOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &txState{tx: tx, db: options.DB, options: options, hooks: &txHooks{}}, nil
+}
+
+// 替换当前协程中指定数据源的事务，返回恢复用的函数
+func setCurrentTxState(name string, state *txState) func() {
+	previous := currentTxLocalValue()
+	local := &txLocal{states: make(map[string]*txState, len(previous.states)+1), active: state}
+	for k, v := range previous.states {
+		local.states[k] = v
+	}
+	local.states[name] = state
+	return currentTxLocal.store.swap(local)
+}
+
+// 使用指定的事务状态执行fn，执行期间当前协程的事务也会被替换
+func withTxState(ctx context.Context, name string, state *txState, fn func(ctx context.Context) error) error {
+	defer setCurrentTxState(name, state)()
+	ctx = context.WithValue(ctx, txContextKey{db: name}, state)
+	return fn(context.WithValue(ctx, activeTxContextKey{}, state))
+}
+
+// 提交事务，之后执行对应的回调，提交失败时视为回滚
+func (state *txState) commit() error {
+	err := state.tx.Commit()
+	hooks := state.copyHooks()
+	if err != nil {
+		runHooks(hooks.rollback)
+		return err
+	}
+	runHooks(hooks.commit)
+	return nil
+}
+
+func (state *txState) rollback() error {
+	err := state.tx.Rollback()
+	runHooks(state.copyHooks().rollback)
+	return err
+}
+
+// 结束事务，cause为导致回滚的错误，失败时返回同时包含两个错误的TransactionError
+func (state *txState) finish(rollback bool, cause error) error {
+	op := "commit"
+	var err error
+	if rollback {
+		op = "rollback"
+		err = state.rollback()
+	} else {
+		err = state.commit()
+	}
+	if err != nil {
+		log.Printf("vermouth: transaction %s failed: %v, cause: %v", op, err, cause)
+		return &TransactionError{Cause: cause, Err: err, Op: op}
+	}
+	return nil
+}
+
+// 按回滚规则判断错误是否需要回滚，没有错误时不回滚
+func (options TxOptions) shouldRollback(err error) bool {
+	if err == nil {
+		return false
+	}
+	if matchErrorType(err, options.NoRollbackFor) {
+		return false
+	}
+	if len(options.RollbackFor) > 0 {
+		return matchErrorType(err, options.RollbackFor)
+	}
+	return true
+}
+
+// 错误链上是否有类型名匹配的错误
+func matchErrorType(err error, names []string) bool {
+	if len(names) == 0 {
+		return false
+	}
+	for ; err != nil; err = errors.Unwrap(err) {
+		t := reflect.TypeOf(err)
+		base := t
+		if base.Kind() == reflect.Ptr {
+			base = base.Elem()
+		}
+		for _, name := range names {
+			if name == t.String() || name == base.String() || name == base.Name() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 将panic的值转换为error，用于匹配回滚规则
+func panicError(e interface{}) error {
+	if err, ok := e.(error); ok {
+		return err
+	}
+	return fmt.Errorf("%v", e)
+}
+
+// 加入外层的事务，失败时将事务标记为只能回滚
+func joinTx(ctx context.Context, options TxOptions, state *txState, fn func(ctx context.Context) error) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			if !matchErrorType(panicError(e), options.NoRollbackFor) {
+				state.setRollbackOnly(true)
+			}
+			panic(e)
+		}
+	}()
+	err = withTxState(ctx, options.DB, state, fn)
+	if options.shouldRollback(err) {
+		state.setRollbackOnly(true)
+	}
+	return err
+}
+
+func (state *txState) setRollbackOnly(rollbackOnly bool) bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	previous := state.rollbackOnly
+	state.rollbackOnly = rollbackOnly
+	return previous
+}
+
+func (state *txState) isRollbackOnly() bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.rollbackOnly
+}
+
+// 在新的物理事务中执行，结束后提交或回滚
+func runInTx(ctx context.Context, state *txState, fn func(ctx context.Context) error) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			cause := panicError(e)
+			if err := state.finish(!matchErrorType(cause, state.options.NoRollbackFor), cause); err != nil {
+				panic(err)
+			}
+			panic(e)
+		}
+	}()
+	err = withTxState(ctx, state.db, state, fn)
+	// 内层加入的调用失败时，外层即使忽略了错误也要回滚
+	if err == nil && state.isRollbackOnly() {
+		if txErr := state.finish(true, ErrRollbackOnly); txErr != nil {
+			return txErr
+		}
+		return ErrRollbackOnly
+	}
+	if txErr := state.finish(state.options.shouldRollback(err), err); txErr != nil {
+		return txErr
+	}
+	return err
+}
+
+// 使用savepoint执行嵌套事务，失败时只回滚到savepoint
+func runInSavepoint(ctx context.Context, state *txState, fn func(ctx context.Context) error) (err error) {
+	state.mu.Lock()
+	state.savepoints++
+	name := fmt.Sprintf("vermouth_sp_%d", state.savepoints)
+	state.mu.Unlock()
+	if _, err = state.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+	// savepoint中注册的回调单独保存，释放后再合并到外层
+	hooks := &txHooks{}
+	parent := state.swapHooks(hooks)
+	rollbackOnly := state.isRollbackOnly()
+	rollback := func() {
+		state.swapHooks(parent)
+		// 回滚到savepoint后，其中失败的调用不再影响外层
+		state.setRollbackOnly(rollbackOnly)
+		state.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+		runHooks(hooks.rollback)
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			rollback()
+			panic(e)
+		}
+	}()
+	if err = withTxState(ctx, state.db, state, fn); err != nil {
+		rollback()
+		return err
+	}
+	state.swapHooks(parent)
+	if _, err = state.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		runHooks(hooks.rollback)
+		return err
+	}
+	state.mu.Lock()
+	parent.commit = append(parent.commit, hooks.commit...)
+	parent.rollback = append(parent.rollback, hooks.rollback...)
+	state.mu.Unlock()
+	return nil
+}
+
 func initTransactionManager() {
 	once.Do(func() {
 		// 注册事务处理器
 		RegisterAop("/**", 0, func(aopContext *Context) {
-			var tx *sql.Tx
-			defer func() {
-				if err := recover(); err != nil {
-					if tx != nil {
-						// 回滚事务
-						err = tx.Rollback()
-						if err != nil {
+			// 判断是否需要事务，渐进式覆盖转发的写请求总是在事务中执行并回滚
+			shadow := isCoverRollback(aopContext)
+			if !aopContext.ControllerInformation.Transaction && !shadow {
+				aopContext.Call()
+				return
+			}
+			// 开启事务
+			options := aopContext.ControllerInformation.TxOptions
+			state, err := beginTx(aopContext.HttpContext.Request().Context(), options)
+			if err != nil {
+				panic(err)
+			}
+			aopContext.HttpContext.Set(txKey("Vermouth:tx", options.DB), state.tx)
+			aopContext.HttpContext.Set(txKey("Vermouth:txState", options.DB), state)
+			defer setCurrentTxState(options.DB, state)()
+			func() {
+				defer func() {
+					if e := recover(); e != nil {
+						// 异常只有匹配no_rollback_for时才提交，之后继续抛出，交给错误处理器
+						cause := panicError(e)
+						if err := state.finish(shadow || !matchErrorType(cause, options.NoRollbackFor), cause); err != nil {
 							panic(err)
 						}
+						panic(e)
 					}
-
-					// 判断是否是自定义错误
-					//if myErr, ok := err.(*RuntimeError); ok {
-					//	aopContext.GinContext.JSON(myErr.Code, myErr.Message)
-					//	return
-					//}
-					// 不是我的异常，抛回给中间件处理
-					panic(err)
-				}
+				}()
+				// 执行方法
+				aopContext.Call()
 			}()
-			// 判断是否需要事务
-			if aopContext.ControllerInformation.Transaction {
-				// 开启事务
-				// fmt.Println("开启事务")
-				var err error
-				tx, err = GetDB().Begin()
-				if err != nil {
-					// todo 回滚事务
-					panic(err)
+			// 返回了错误或者满足回滚规则时回滚，否则提交
+			cause := resultError(aopContext.Result)
+			rollback := shadow || options.shouldRollback(cause)
+			for _, name := range aopContext.ControllerInformation.RollbackWhen {
+				if fn, ok := rollbackRules[name]; ok && !rollback {
+					rollback = fn(aopContext)
+				}
+			}
+			// 内层加入的调用失败时，即使方法忽略了错误也要回滚，没有返回错误时抛出ErrRollbackOnly
+			rollbackOnly := false
+			if !rollback && state.isRollbackOnly() {
+				rollback = true
+				if cause == nil {
+					cause, rollbackOnly = ErrRollbackOnly, true
 				}
-				aopContext.GinContext.Set("Vermouth:tx", tx)
-			}
-			// 执行方法
-			aopContext.Call()
-			// 提交事务
-			if tx != nil {
-				err := tx.Commit()
-				if err != nil {
+			}
+			if err := state.finish(rollback, cause); err != nil {
+				if cause == nil || rollbackOnly {
 					panic(err)
 				}
+				// 替换返回的错误，交给错误处理器
+				aopContext.Result[len(aopContext.Result)-1] = err
+			} else if rollbackOnly {
+				panic(ErrRollbackOnly)
 			}
 		})
 	})
 }
+
+var isolationLevels = map[string]sql.IsolationLevel{
+	"default":          sql.LevelDefault,
+	"read_uncommitted": sql.LevelReadUncommitted,
+	"read_committed":   sql.LevelReadCommitted,
+	"write_committed":  sql.LevelWriteCommitted,
+	"repeatable_read":  sql.LevelRepeatableRead,
+	"snapshot":         sql.LevelSnapshot,
+	"serializable":     sql.LevelSerializable,
+	"linearizable":     sql.LevelLinearizable,
+}
+
+// 解析transaction tag，例如 transaction:"db=orders,isolation=serializable,readonly"
+// 只写true时使用默认数据源和默认的隔离级别
+func parseTransactionTag(tag string) (enabled bool, options TxOptions, problems []string) {
+	tag = strings.TrimSpace(tag)
+	if tag == "" || tag == "false" {
+		return false, options, nil
+	}
+	for _, item := range strings.Split(tag, ",") {
+		item = strings.TrimSpace(item)
+		parts := strings.SplitN(item, "=", 2)
+		key := parts[0]
+		value := ""
+		if len(parts) == 2 {
+			value = strings.TrimSpace(parts[1])
+		}
+		switch key {
+		case "true", "":
+		case "db":
+			options.DB = value
+		case "isolation":
+			level, ok := isolationLevels[strings.NewReplacer("-", "_", " ", "_").Replace(strings.ToLower(value))]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("unknown transaction isolation %q", value))
+			}
+			options.Isolation = level
+		case "readonly":
+			options.ReadOnly = value == "" || value == "true"
+		default:
+			problems = append(problems, fmt.Sprintf("unknown transaction option %q", key))
+		}
+	}
+	return true, options, problems
+}
@@ -68,7 +68,7 @@ func initValidator() {
 }
 
 type ValidatorError struct {
-	ErrorMessages []string `json:messages`
+	ErrorMessages []string `json:"messages"`
 }
 
 func (e *ValidatorError) Error() string {
@@ -106,28 +106,17 @@ func makeValidatorError(u interface{}, ers validator.ValidationErrors) *Validato
 	return ve
 }
 
-// 获取字段和标签的递归函数
+// 根据校验错误的命名空间获取字段和标签，例如Items[2].Sku
+// 以下标结尾时（使用dive校验切片和map的元素），返回切片或map所在的字段
 func getFieldAndTag(t reflect.Type, ns, tagName string) (reflect.StructField, string) {
-	if t.Kind() == reflect.Ptr {
-		t = t.Elem()
-	}
-	if t.Kind() != reflect.Struct {
+	field, err := fieldByPath(t, ns)
+	if err != nil {
 		return reflect.StructField{}, ""
 	}
-
-	parts := strings.Split(ns, ".")
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		if field.Name == parts[0] {
-			if len(parts) == 1 {
-				return field, field.Tag.Get(tagName)
-			}
-			if field.Type.Kind() == reflect.Struct || (field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Struct) {
-				return getFieldAndTag(field.Type, strings.Join(parts[1:], "."), tagName)
-			}
-		}
+	if tag, ok := field.Tags[tagName]; ok {
+		return *field.StructField, tag.Value
 	}
-	return reflect.StructField{}, ""
+	return *field.StructField, ""
 }
 
 // 解析 message 字符串